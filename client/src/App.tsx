@@ -5,6 +5,8 @@ import { Touchpad } from "./components/Touchpad";
 import { SensorLog } from "./components/SensorLog";
 import { PermissionPrompt } from "./components/PermissionPrompt";
 import { CalibrationDialog } from "./components/CalibrationDialog";
+import PairingCodeForm from "./components/PairingCodeForm";
+import ScanQrPrompt from "./components/ScanQrPrompt";
 import {
   handleTouchStart,
   handleTouchMove,
@@ -14,6 +16,27 @@ import {
   handleRightTouchStart,
   handleRightTouchEnd,
 } from "./touchHandlers";
+import { verifyServerFingerprint } from "./fingerprint";
+
+// must match server.ProtocolVersion
+const PROTOCOL_VERSION = 2;
+
+// localStorage key holding the device token from a trusted pairing
+const DEVICE_TOKEN_KEY = "quickMouseDeviceToken";
+
+// sessionStorage keys for the QR key and fingerprint. They are stripped from the URL,
+// so this is what lets a reload or reconnect in the same tab find them again
+const SESSION_KEY_KEY = "quickMouseSessionKey";
+const SESSION_FINGERPRINT_KEY = "quickMouseFingerprint";
+
+// user facing text for each failed auth_result from the server
+const authErrorMessages: Record<string, string> = {
+  invalid_key: "This pairing code is not valid. Scan the QR code again.",
+  expired_key: "This pairing code has expired. Scan the new QR code.",
+  too_many_attempts: "Too many failed attempts. Wait a moment and try again.",
+  protocol_mismatch: "This app and the server are different versions. Update both and try again.",
+  fingerprint_mismatch: "This server's certificate does not match the QR code. Do not continue on this network.",
+};
 
 export default function App() {
   const [isLeftPressed, setIsLeftPressed] = useState(false);
@@ -125,6 +148,10 @@ export default function App() {
   const [lastMessageTime, setLastMessageTime] = useState(Date.now());
   const [authKey, setAuthKey] = useState<string>("");
   const isMountedRef = useRef(true);
+  const [authError, setAuthError] = useState<string | null>(null);
+  const authErrorRef = useRef<string | null>(null);
+  const [needsPairingCode, setNeedsPairingCode] = useState(false);
+  const [needsQrScan, setNeedsQrScan] = useState(false);
 
   type Packet = {
     type: string;
@@ -142,8 +169,15 @@ export default function App() {
     websocket.onopen = () => {
       if (isMountedRef.current) {
         setConnectionStatus("connected");
-        // Send auth packet immediately
-        const authPacket = { type: "auth", key };
+        // Send auth packet immediately, announcing what protocol we speak
+        const authPacket = {
+          type: "auth",
+          key,
+          protocolVersion: PROTOCOL_VERSION,
+          serializers: ["json"],
+          capabilities: [],
+          deviceToken: localStorage.getItem(DEVICE_TOKEN_KEY) ?? "",
+        };
         websocket.send(JSON.stringify(authPacket));
       }
     };
@@ -154,6 +188,37 @@ export default function App() {
         try {
           const parsedData = JSON.parse(event.data);
 
+          // Server closes the connection right after a failed auth result
+          if (parsedData.type === 'auth_result') {
+            if (parsedData.result !== 'success') {
+              console.error("Server rejected connection:", parsedData.result, parsedData.message);
+              // A revoked device token is useless, fall back to scanning the QR code
+              if (parsedData.result === 'invalid_key') {
+                localStorage.removeItem(DEVICE_TOKEN_KEY);
+              }
+              // The key was rotated or is wrong, reloading with it would only fail again
+              if (parsedData.result === 'invalid_key' || parsedData.result === 'expired_key') {
+                sessionStorage.removeItem(SESSION_KEY_KEY);
+                sessionStorage.removeItem(SESSION_FINGERPRINT_KEY);
+              }
+              if (parsedData.result === 'expired_key') {
+                setNeedsQrScan(true);
+              }
+              authErrorRef.current = parsedData.result;
+              setAuthError(parsedData.result);
+              setConnectionStatus("error");
+            }
+            return;
+          }
+
+          // Server paired this device, keep the token so we can reconnect after restarts
+          if (parsedData.type === 'auth_ok') {
+            if (parsedData.deviceToken) {
+              localStorage.setItem(DEVICE_TOKEN_KEY, parsedData.deviceToken);
+            }
+            return;
+          }
+
           // Handle config sync packets
           if (parsedData.type === 'config_sync') {
             setPointerSensitivity(parsedData.pointerSensitivity || 5);
@@ -173,6 +238,10 @@ export default function App() {
 
     websocket.onclose = () => {
       if (isMountedRef.current) {
+        // Retrying with a rejected key or an incompatible client will never succeed
+        if (authErrorRef.current === 'invalid_key' || authErrorRef.current === 'expired_key' || authErrorRef.current === 'protocol_mismatch') {
+          return;
+        }
         setConnectionStatus("disconnected");
         // Attempt to reconnect after a delay if component is still mounted
         setTimeout(() => {
@@ -382,12 +451,69 @@ export default function App() {
   // WebSocket connection management
   useEffect(() => {
     const urlParams = new URLSearchParams(window.location.search);
-    const key = urlParams.get("key");
+    const urlKey = urlParams.get("key");
+    // Keep the key out of browser history now that we have it, this tab still needs
+    // it for reloads and reconnects
+    if (urlKey) {
+      sessionStorage.setItem(SESSION_KEY_KEY, urlKey);
+      const urlFingerprint = urlParams.get("fp");
+      if (urlFingerprint) {
+        sessionStorage.setItem(SESSION_FINGERPRINT_KEY, urlFingerprint);
+      } else {
+        sessionStorage.removeItem(SESSION_FINGERPRINT_KEY);
+      }
+      window.history.replaceState(null, "", window.location.pathname);
+    }
+    const key = sessionStorage.getItem(SESSION_KEY_KEY);
+    const expectedFingerprint = sessionStorage.getItem(SESSION_FINGERPRINT_KEY);
+
+    const connect = (connectKey: string) => {
+      if (!expectedFingerprint) {
+        connectWebSocket(connectKey);
+        return;
+      }
+      // Make sure we are talking to the machine whose QR code we scanned before sending the key
+      verifyServerFingerprint(expectedFingerprint, connectKey)
+        .then((matches) => {
+          if (!isMountedRef.current) {
+            return;
+          }
+          if (matches) {
+            connectWebSocket(connectKey);
+          } else {
+            console.error("Server certificate does not match the scanned QR code");
+            authErrorRef.current = "fingerprint_mismatch";
+            setAuthError("fingerprint_mismatch");
+            setConnectionStatus("error");
+          }
+        })
+        .catch((error) => {
+          console.error("Failed to verify server certificate:", error);
+          setConnectionStatus("error");
+        });
+    };
+
     if (key) {
       setAuthKey(key);
-      connectWebSocket(key);
+      connect(key);
+    } else if (localStorage.getItem(DEVICE_TOKEN_KEY)) {
+      // Paired devices can reconnect with their device token alone
+      connect("");
     } else {
-      setConnectionStatus("error");
+      // No QR code scanned, fall back to typing the pairing code if the server
+      // offers it. /pair only exists when pairing codes are turned on
+      fetch("/pair", { cache: "no-store" })
+        .then((response) => {
+          if (!isMountedRef.current) {
+            return;
+          }
+          if (response.status === 404) {
+            setNeedsQrScan(true);
+          } else {
+            setNeedsPairingCode(true);
+          }
+        })
+        .catch(() => setNeedsQrScan(true));
     }
 
     return () => {
@@ -439,6 +565,26 @@ export default function App() {
 
 
 
+  if (needsQrScan) {
+    return (
+      <div style={{ display: 'flex', justifyContent: 'center', alignItems: 'center', height: '100vh' }}>
+        <ScanQrPrompt
+          message={authError === "expired_key"
+            ? "This QR code has been replaced. Scan the new QR code shown on the computer."
+            : "Scan the QR code shown on the computer running Quick Mouse."}
+        />
+      </div>
+    );
+  }
+
+  if (needsPairingCode) {
+    return (
+      <div style={{ display: 'flex', justifyContent: 'center', alignItems: 'center', height: '100vh' }}>
+        <PairingCodeForm />
+      </div>
+    );
+  }
+
   // Wait for config before rendering main UI
   if (!configLoaded || !Object.values({
       pointerSensitivity,
@@ -465,7 +611,7 @@ export default function App() {
         </div>
         {connectionStatus === "error" && (
           <div style={{ fontSize: '14px', color: '#666' }}>
-            Connection failed. Please refresh the page.
+            {authErrorMessages[authError ?? ""] ?? "Connection failed. Please refresh the page."}
           </div>
         )}
       </div>
@@ -586,6 +732,7 @@ export default function App() {
                 setSwipeDirection,
                 setSwipeMagnitude,
                 rafIdRef,
+                sendPacket,
               )
             }
             permissionState={appPhase === "main" ? "granted" : "denied"}
@@ -0,0 +1,68 @@
+import { useState } from "react";
+import Box from "@mui/material/Box";
+import Button from "@mui/material/Button";
+import TextField from "@mui/material/TextField";
+import Typography from "@mui/material/Typography";
+
+const pairErrorMessages: Record<string, string> = {
+  invalid_key: "That code is not right. Check the code on the computer screen.",
+  expired_key: "That code has expired. Enter the new code shown on the computer.",
+  too_many_attempts: "Too many wrong codes. A new code is now shown on the computer.",
+};
+
+export default function PairingCodeForm() {
+  const [code, setCode] = useState("");
+  const [error, setError] = useState<string | null>(null);
+  const [submitting, setSubmitting] = useState(false);
+
+  const submit = async () => {
+    setSubmitting(true);
+    setError(null);
+    try {
+      const response = await fetch("/pair", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ code }),
+      });
+      if (response.status === 404) {
+        setError("Pairing codes are turned off on this computer. Scan the QR code instead.");
+        return;
+      }
+      const data = await response.json();
+      if (data.result === "success" && data.key) {
+        // Same flow as scanning the QR code from here on
+        window.location.replace(`/?key=${encodeURIComponent(data.key)}`);
+        return;
+      }
+      setError(pairErrorMessages[data.result] ?? "Pairing failed. Please try again.");
+      setCode("");
+    } catch (err) {
+      console.error("Failed to redeem pairing code:", err);
+      setError("Could not reach the computer. Please try again.");
+    } finally {
+      setSubmitting(false);
+    }
+  };
+
+  return (
+    <Box sx={{ display: "flex", flexDirection: "column", alignItems: "center", gap: 2, p: 4, maxWidth: 320 }}>
+      <Typography variant="h6">Enter pairing code</Typography>
+      <Typography sx={{ color: "text.secondary", textAlign: "center" }}>
+        Type the 6-digit code shown on the computer running Quick Mouse.
+      </Typography>
+      <TextField
+        value={code}
+        onChange={(e) => setCode(e.target.value.replace(/\D/g, "").slice(0, 6))}
+        inputProps={{ inputMode: "numeric", autoComplete: "one-time-code", style: { textAlign: "center", letterSpacing: "0.4em", fontSize: 24 } }}
+        disabled={submitting}
+        fullWidth
+      />
+      {error && (
+        <Typography sx={{ color: "error.main", textAlign: "center", fontSize: 14 }}>{error}</Typography>
+      )}
+      <Button variant="contained" onClick={submit} disabled={code.length !== 6 || submitting} fullWidth>
+        Pair
+      </Button>
+    </Box>
+  );
+}
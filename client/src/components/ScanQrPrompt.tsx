@@ -0,0 +1,18 @@
+import Box from "@mui/material/Box";
+import Typography from "@mui/material/Typography";
+import QrCodeScannerIcon from "@mui/icons-material/QrCodeScanner";
+
+interface ScanQrPromptProps {
+  message: string;
+}
+
+// Shown when there is no usable key and typing a pairing code is not an option
+export default function ScanQrPrompt({ message }: ScanQrPromptProps) {
+  return (
+    <Box sx={{ display: "flex", flexDirection: "column", alignItems: "center", gap: 2, p: 4, maxWidth: 320 }}>
+      <QrCodeScannerIcon sx={{ fontSize: 48, color: "primary.main" }} />
+      <Typography variant="h6">Scan the QR code</Typography>
+      <Typography sx={{ color: "text.secondary", textAlign: "center" }}>{message}</Typography>
+    </Box>
+  );
+}
@@ -0,0 +1,46 @@
+// The browser never lets us look at the TLS certificate directly, so we ask the
+// server which certificate it is serving and compare it to the fingerprint that was
+// in the QR code. The nonce/proof exchange makes sure the answer came from whoever
+// holds the pairing key.
+
+const toHex = (buffer: ArrayBuffer) =>
+  Array.from(new Uint8Array(buffer))
+    .map((b) => b.toString(16).padStart(2, "0"))
+    .join("");
+
+const hmacHex = async (key: string, message: string) => {
+  const encoder = new TextEncoder();
+  const cryptoKey = await crypto.subtle.importKey(
+    "raw",
+    encoder.encode(key),
+    { name: "HMAC", hash: "SHA-256" },
+    false,
+    ["sign"],
+  );
+  return toHex(await crypto.subtle.sign("HMAC", cryptoKey, encoder.encode(message)));
+};
+
+export const verifyServerFingerprint = async (
+  expected: string,
+  key: string,
+): Promise<boolean> => {
+  const nonce = toHex(crypto.getRandomValues(new Uint8Array(16)).buffer);
+  const response = await fetch(`/fingerprint?nonce=${nonce}`, { cache: "no-store" });
+  if (!response.ok) {
+    return false;
+  }
+
+  const { fingerprint, proof, proofs } = await response.json();
+  if (typeof fingerprint !== "string" || fingerprint.toLowerCase() !== expected.toLowerCase()) {
+    return false;
+  }
+  if (!key) {
+    // Nothing to check the proof against when reconnecting with a device token
+    return true;
+  }
+  // One proof per key the server still knows, so a key that has since been rotated
+  // still verifies. Older servers only send the current key's proof
+  const expectedProof = await hmacHex(key, `${nonce}:${fingerprint}`);
+  const known: unknown[] = Array.isArray(proofs) ? proofs : [proof];
+  return known.includes(expectedProof);
+};
@@ -106,8 +106,13 @@ export const handleTouchEnd = (
   setTouchActive: (active: boolean) => void,
   setSwipeDirection: (direction: string) => void,
   setSwipeMagnitude: (magnitude: number) => void,
-  rafIdRef: React.MutableRefObject<number | null>
+  rafIdRef: React.MutableRefObject<number | null>,
+  sendPacket: (packet: Packet) => void
 ) => {
+  // lifting from a two finger scroll lets the server start kinetic scrolling
+  if (initialTouchesRef.current.length >= 2) {
+    sendPacket({ type: "scroll_end" });
+  }
   initialTouchesRef.current = [];
   setTouchActive(false);
   setSwipeDirection("None");
@@ -1,8 +1,8 @@
 package main
 
 import (
-	"crypto/rand"
-	"encoding/hex"
+	"bufio"
+	"crypto/tls"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -12,6 +12,9 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -22,14 +25,22 @@ import (
 )
 
 type Config struct {
-	LastPort             int     `json:"lastPort"`
-	PointerSensitivity   float64 `json:"pointerSensitivity"`
-	HandheldSensitivity  float64 `json:"handheldSensitivity"`
-	ScrollSensitivity    float64 `json:"scrollSensitivity"`
-	ShowSensorLog        bool    `json:"showSensorLog"`
-	ButtonsAboveTouchpad bool    `json:"buttonsAboveTouchpad"`
-	NaturalScroll        bool    `json:"naturalScroll"`
-	SwapLeftRightClick   bool    `json:"swapLeftRightClick"`
+	LastPort             int                       `json:"lastPort"`
+	PointerSensitivity   float64                   `json:"pointerSensitivity"`
+	HandheldSensitivity  float64                   `json:"handheldSensitivity"`
+	ScrollSensitivity    float64                   `json:"scrollSensitivity"`
+	ShowSensorLog        bool                      `json:"showSensorLog"`
+	ButtonsAboveTouchpad bool                      `json:"buttonsAboveTouchpad"`
+	NaturalScroll        bool                      `json:"naturalScroll"`
+	SwapLeftRightClick   bool                      `json:"swapLeftRightClick"`
+	ArbitrationPolicy    string                    `json:"arbitrationPolicy"`
+	TrustDevices         bool                      `json:"trustDevices"`
+	Interface            string                    `json:"interface"`
+	AllowedOrigins       []string                  `json:"allowedOrigins"`
+	DoubleClickGapMs     int                       `json:"doubleClickGapMs"` // 0 uses server.DefaultDoubleClickGap
+	KineticScroll        bool                      `json:"kineticScroll"`
+	Acceleration         server.AccelerationConfig `json:"acceleration"`
+	Physics              server.PhysicsParams      `json:"physics"`
 }
 
 var appConfig Config
@@ -37,18 +48,86 @@ var configMutex sync.RWMutex
 
 // this is how we get the ip of the pc to host correctly
 func getLocalIP() string {
-	addrs, err := net.InterfaceAddrs()
-	if err != nil {
-		return "localhost"
+	// when bound to a single address that is the only one that will work
+	if ip := net.ParseIP(*bindArg); ip != nil && !ip.IsUnspecified() {
+		return ip.String()
 	}
-	for _, addr := range addrs {
-		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
-			if ipnet.IP.To4() != nil {
-				return ipnet.IP.String()
+	if selectedInterface != "" {
+		if iface, err := net.InterfaceByName(selectedInterface); err == nil {
+			if addrs := server.InterfaceAddresses(*iface); len(addrs) > 0 {
+				return addrs[0].IP.String()
 			}
 		}
 	}
-	return "localhost"
+	candidates, err := server.ListAddressCandidates()
+	if err != nil || len(candidates) == 0 {
+		return "localhost"
+	}
+	return candidates[0].IP.String()
+}
+
+// host:port for urls, brackets ipv6 addresses
+func localHostPort() string {
+	return net.JoinHostPort(getLocalIP(), strconv.Itoa(*portArg))
+}
+
+// decides which interface's address goes in the qr code, asking the user when
+// there is more than one real candidate and nothing was picked before
+func chooseInterface() {
+	if *interfaceArg != "" {
+		if _, err := net.InterfaceByName(*interfaceArg); err != nil {
+			log.Fatalf("Unknown interface %s: %v", *interfaceArg, err)
+		}
+		selectedInterface = *interfaceArg
+		return
+	}
+	if *bindArg != "" {
+		return
+	}
+
+	candidates, err := server.ListAddressCandidates()
+	if err != nil {
+		return
+	}
+	// first (best) address per interface
+	var choices []server.AddressCandidate
+	for _, candidate := range candidates {
+		if !slices.ContainsFunc(choices, func(c server.AddressCandidate) bool { return c.Interface == candidate.Interface }) {
+			choices = append(choices, candidate)
+		}
+	}
+
+	saved := getConfig().Interface
+	if slices.ContainsFunc(choices, func(c server.AddressCandidate) bool { return c.Interface == saved }) {
+		selectedInterface = saved
+		return
+	}
+	if len(choices) <= 1 || !stdinIsTerminal() {
+		return
+	}
+
+	fmt.Print("Several networks found, which one is your phone on?\n\n")
+	for i, choice := range choices {
+		fmt.Printf("  %d) %-12s %s\n", i+1, choice.Interface, choice.IP)
+	}
+	fmt.Printf("\nChoice [1]: ")
+
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	index := 0
+	if n, err := strconv.Atoi(strings.TrimSpace(line)); err == nil && n >= 1 && n <= len(choices) {
+		index = n - 1
+	}
+	selectedInterface = choices[index].Interface
+
+	// remember the pick so we only ask once per network setup
+	config := getConfig()
+	config.Interface = selectedInterface
+	updateConfig(config)
+}
+
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
 
 // loadConfig loads configuration from file or creates default config
@@ -66,6 +145,10 @@ func loadConfig() {
 		ButtonsAboveTouchpad: true,
 		NaturalScroll:        false,
 		SwapLeftRightClick:   false,
+		ArbitrationPolicy:    string(server.ArbitrationLastActive),
+		TrustDevices:         false,
+		Acceleration:         server.DefaultAccelerationConfig,
+		Physics:              server.DefaultPhysicsParams,
 	}
 
 	data, err := os.ReadFile("config.json")
@@ -75,6 +158,8 @@ func loadConfig() {
 		return
 	}
 
+	// settings missing from an older config file keep their defaults
+	appConfig = defaultConfig
 	if err := json.Unmarshal(data, &appConfig); err != nil {
 		appConfig = defaultConfig
 		saveConfig()
@@ -106,22 +191,79 @@ func updateConfig(newConfig Config) {
 	saveConfig()
 }
 
-// landingPageHandler serves an HTML page with a WebSocket test client
-// this is soley for testing
-func landingPageHandler(w http.ResponseWriter, r *http.Request) {
-	localIP := getLocalIP()
-	wsURL := fmt.Sprintf("wss://%s:3000/ws", localIP)
-	key := r.URL.Query().Get("key")
+// debugUIHandler serves a websocket test client, only registered with --debug-ui
+// the page is only handed out to a form post carrying the current key. the key is
+// written into the page for its script to connect with, so the response is never
+// cached. its packet sender is built from the packet registry
+func debugUIHandler(w http.ResponseWriter, r *http.Request) {
+	host := remoteHost(r)
+	if wait, _ := authGuard.Check(host, time.Now()); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "too many attempts", http.StatusTooManyRequests)
+		return
+	}
+
+	// the key only comes in a form post, in a query string it would end up in
+	// history, proxy logs and referers
+	key := ""
+	if r.Method == http.MethodPost {
+		key = r.PostFormValue("key")
+	}
+	if key == "" {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(debugUIKeyForm))
+		return
+	}
+	if sessionKeys.Check(key) != server.KeyValid {
+		authFailure(host)
+		http.Error(w, "invalid auth key", http.StatusForbidden)
+		return
+	}
+	authGuard.Success(host)
 
-	html := `<!DOCTYPE html>
+	// json.Marshal escapes < and > so this is safe to drop into a script tag
+	templates, err := json.Marshal(server.PacketTemplates())
+	if err != nil {
+		http.Error(w, "failed to build packet list", http.StatusInternalServerError)
+		return
+	}
+	wsURL, _ := json.Marshal(fmt.Sprintf("wss://%s/ws", localHostPort()))
+	authKey, _ := json.Marshal(key)
+	page := strings.NewReplacer(
+		"{{WS_URL}}", string(wsURL),
+		"{{AUTH_KEY}}", string(authKey),
+		"{{PACKETS}}", string(templates),
+		"{{PROTOCOL_VERSION}}", strconv.Itoa(server.ProtocolVersion),
+	).Replace(debugUIPage)
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Write([]byte(page))
+}
+
+const debugUIKeyForm = `<!DOCTYPE html>
+<html>
+<head><title>Mouse Control Test Client</title></head>
+<body style="font-family: Arial, sans-serif; margin: 30px; font-size: 18px;">
+    <h1>Mouse Control Test Client</h1>
+    <form method="post" action="/test">
+        <label>Auth Key: <input type="password" name="key" autocomplete="off"></label>
+        <button type="submit">Open</button>
+    </form>
+</body>
+</html>`
+
+const debugUIPage = `<!DOCTYPE html>
 <html>
 <head>
     <title>Mouse Control Test Client</title>
     <style>
         body { font-family: Arial, sans-serif; margin: 30px; font-size: 18px; }
         .section { margin: 30px 0; padding: 25px; border: 2px solid #ccc; border-radius: 8px; }
-        .input-group { margin: 15px 0; }
-        input[type="number"] { width: 120px; margin-right: 15px; padding: 8px; font-size: 18px; }
+        .packet { display: inline-block; vertical-align: top; margin: 10px; }
+        .packet textarea { display: block; width: 320px; height: 140px; font-family: monospace; font-size: 14px; }
         input[type="text"] { width: 300px; padding: 8px; font-size: 18px; }
         button { margin: 8px; padding: 12px 24px; cursor: pointer; font-size: 18px; border-radius: 5px; }
         .status { padding: 15px; margin: 15px 0; border-radius: 5px; font-size: 18px; }
@@ -135,55 +277,24 @@ func landingPageHandler(w http.ResponseWriter, r *http.Request) {
 </head>
 <body>
     <h1>Mouse Control Test Client</h1>
-    <p>WebSocket URL: ` + wsURL + `</p>
-    <p>Auth Key: ` + key + `</p>
 
     <div class="section">
         <h3>Connection</h3>
-        <button onclick="connectWithCorrectKey()">Connect with Correct Key</button>
-        <button onclick="connectWithWrongKey()">Connect with Wrong Key</button>
-        <div class="input-group">
+        <button onclick="connect(authKey)">Connect</button>
+        <button onclick="connect('invalid_key_12345')">Connect with Wrong Key</button>
+        <div>
             <label>Custom Key: <input type="text" id="customKey" placeholder="Enter custom key"></label>
-            <button onclick="connectWithCustomKey()">Connect with Custom Key</button>
+            <button onclick="connect(document.getElementById('customKey').value)">Connect with Custom Key</button>
         </div>
+        <label><input type="checkbox" id="hideKeepAlive" checked> Hide keep alives</label>
         <button onclick="disconnect()">Disconnect</button>
         <div id="status" class="status disconnected">Disconnected</div>
     </div>
 
     <div class="section">
-        <h3>Mouse Movement</h3>
-        <div class="input-group">
-            <label>X Delta: <input type="number" id="moveX" value="10"></label>
-            <label>Y Delta: <input type="number" id="moveY" value="10"></label>
-            <button onclick="sendMouseMove()">Move Mouse</button>
-        </div>
-    </div>
-
-    <div class="section">
-        <h3>Mouse Clicks</h3>
-        <button onclick="sendPacket('left_click_down')">Left Click Down</button>
-        <button onclick="sendPacket('left_click_up')">Left Click Up</button>
-        <button onclick="sendPacket('right_click_down')">Right Click Down</button>
-        <button onclick="sendPacket('right_click_up')">Right Click Up</button>
-    </div>
-
-    <div class="section">
-        <h3>Mouse Scroll</h3>
-        <div class="input-group">
-            <label>X Delta: <input type="number" id="scrollX" value="0"></label>
-            <label>Y Delta: <input type="number" id="scrollY" value="3"></label>
-            <button onclick="sendScroll()">Scroll</button>
-        </div>
-    </div>
-
-    <div class="section">
-        <h3>Control Mode</h3>
-        <button onclick="sendPacket('switch_mode')">Toggle Control Mode</button>
-    </div>
-
-    <div class="section">
-        <h3>Other Packets</h3>
-        <button onclick="sendPacket('unknown_packet')">Unknown Packet (Test Error)</button>
+        <h3>Packets</h3>
+        <div id="packets"></div>
+        <button onclick="sendRaw('{&quot;type&quot;: &quot;unknown_packet&quot;}')">Unknown Packet (Test Error)</button>
     </div>
 
     <div class="section">
@@ -192,34 +303,54 @@ func landingPageHandler(w http.ResponseWriter, r *http.Request) {
     </div>
 
     <script>
+        const wsURL = {{WS_URL}};
+        const packets = {{PACKETS}};
         let socket;
         const statusDiv = document.getElementById('status');
         const messagesDiv = document.getElementById('messages');
-        const authKey = '` + key + `';
+
+        // posted through the key form, it never shows up in the address bar or history
+        const authKey = {{AUTH_KEY}};
+
+        // one editable template per registered packet type
+        const packetsDiv = document.getElementById('packets');
+        packets.forEach(function(packet) {
+            const div = document.createElement('div');
+            div.className = 'packet';
+            const textarea = document.createElement('textarea');
+            textarea.value = packet.json;
+            const button = document.createElement('button');
+            button.textContent = 'Send ' + packet.type;
+            button.onclick = function() { sendRaw(textarea.value); };
+            div.appendChild(textarea);
+            div.appendChild(button);
+            packetsDiv.appendChild(div);
+        });
 
         function connect(key) {
-            socket = new WebSocket('` + wsURL + `');
+            disconnect();
+            socket = new WebSocket(wsURL);
 
             socket.onopen = function(event) {
-                statusDiv.textContent = 'Authenticated';
+                statusDiv.textContent = 'Connected';
                 statusDiv.className = 'status connected';
-                addMessage('Connected to WebSocket - authenticated');
-                // Send auth packet immediately
-                const authPacket = { type: 'auth', key: key };
-                sendJSON(authPacket);
+                addMessage('Connected to WebSocket');
+                sendRaw(JSON.stringify({ type: 'auth', key: key, protocolVersion: {{PROTOCOL_VERSION}}, deviceName: 'debug ui' }));
             };
 
             socket.onmessage = function(event) {
                 const data = JSON.parse(event.data);
-                if (data.type === 'keep_alive') {
-                    // Keep-alive from server, no action needed
-                } else {
-                    addMessage('Received: ' + event.data);
+                if (data.type === 'keep_alive' && document.getElementById('hideKeepAlive').checked) {
+                    return;
+                }
+                if (data.type === 'auth_result') {
+                    statusDiv.textContent = data.result === 'success' ? 'Authenticated' : 'Rejected: ' + data.result;
                 }
+                addMessage('Received: ' + event.data);
             };
 
             socket.onclose = function(event) {
-                statusDiv.textContent = 'Disconnected';
+                statusDiv.textContent = 'Disconnected (' + event.code + (event.reason ? ' ' + event.reason : '') + ')';
                 statusDiv.className = 'status disconnected';
                 addMessage('Disconnected');
             };
@@ -231,49 +362,23 @@ func landingPageHandler(w http.ResponseWriter, r *http.Request) {
             };
         }
 
-        function connectWithCorrectKey() {
-            connect(authKey);
-        }
-
-        function connectWithWrongKey() {
-            connect('invalid_key_12345');
-        }
-
-        function connectWithCustomKey() {
-            const customKey = document.getElementById('customKey').value;
-            connect(customKey);
-        }
-
         function disconnect() {
             if (socket) {
                 socket.close();
+                socket = null;
             }
         }
 
-        function sendPacket(packetType) {
-            const packet = { type: packetType };
-            sendJSON(packet);
-        }
-
-        function sendMouseMove() {
-            const x = parseInt(document.getElementById('moveX').value) || 0;
-            const y = parseInt(document.getElementById('moveY').value) || 0;
-            const packet = { type: 'mouse_move', x: x, y: y };
-            sendJSON(packet);
-        }
-
-        function sendScroll() {
-            const x = parseInt(document.getElementById('scrollX').value) || 0;
-            const y = parseInt(document.getElementById('scrollY').value) || 0;
-            const packet = { type: 'scroll_move', x: x, y: y };
-            sendJSON(packet);
-        }
-
-        function sendJSON(packet) {
+        function sendRaw(text) {
+            try {
+                JSON.parse(text);
+            } catch (e) {
+                addMessage('Invalid JSON: ' + e.message);
+                return;
+            }
             if (socket && socket.readyState === WebSocket.OPEN) {
-                const jsonStr = JSON.stringify(packet);
-                socket.send(jsonStr);
-                addMessage('Sent: ' + jsonStr);
+                socket.send(text);
+                addMessage('Sent: ' + text);
             } else {
                 addMessage('Not connected');
             }
@@ -285,30 +390,49 @@ func landingPageHandler(w http.ResponseWriter, r *http.Request) {
             messagesDiv.appendChild(p);
             messagesDiv.scrollTop = messagesDiv.scrollHeight;
         }
-
-
     </script>
 </body>
 </html>`
 
-	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(html))
-}
-
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
-		return true // allow all connections since access is controlled by QR code
-		// HACK: this may be a hack but it is fine for now, i dont think we
-		// 		 need any more protection
+		if originPolicy.Check(r) {
+			return true
+		}
+		securityEvent("Rejected websocket from origin %s (%s)", r.Header.Get("Origin"), remoteHost(r))
+		return false
 	},
 }
 
 var serializer server.Serializer = server.JSONSerializer{}
+var binarySerializer server.Serializer = server.BinarySerializer{}
 var controller *server.PacketController
-var connectedClients bool
+var sessions *server.SessionRegistry
+var trustStore *server.TrustStore // nil unless trusted devices are enabled
+var certManager *server.CertManager
+var selectedInterface string          // empty means pick the best candidate automatically
+var pairingCodes *server.PairingCodes // nil unless --pairing-code is set
+var authGuard = server.NewAuthGuard(server.DefaultAuthGuardConfig)
+var originPolicy *server.OriginPolicy
 var logFlag = flag.Bool("log", false, "enable logging of non-movement events")
 var portArg = flag.Int("port", 3000, "enable logging of non-movement events")
+var trustDevicesArg = flag.Bool("trust-devices", false, "remember paired devices so they can reconnect after a restart without scanning")
+var listDevicesArg = flag.Bool("list-devices", false, "list paired devices and exit")
+var revokeDeviceArg = flag.String("revoke-device", "", "revoke the paired device with this id and exit")
+var certArg = flag.String("cert", "", "use this TLS certificate instead of the generated self-signed one (requires --key)")
+var keyArg = flag.String("key", "", "private key for --cert")
+var bindArg = flag.String("bind", "", "address to listen on (default all interfaces)")
+var interfaceArg = flag.String("interface", "", "network interface whose address goes in the QR code")
+var mdnsArg = flag.Bool("mdns", true, "advertise the server on the LAN as a _quickmouse._tcp service")
+var pairingCodeArg = flag.Bool("pairing-code", false, "also show a short numeric code that can be typed in instead of scanning the QR code")
+var allowOriginArg = flag.String("allow-origin", "", "comma separated extra origins allowed to open websockets, e.g. https://my-client.example")
+var keyTTLArg = flag.Duration("key-ttl", 0, "rotate the QR key this often while no device is connected, e.g. 10m (0 never rotates)")
+var oneTimeKeyArg = flag.Bool("one-time-key", false, "rotate the QR key as soon as a device pairs with it")
+var debugUIArg = flag.Bool("debug-ui", false, "serve the websocket test client on /test (needs the auth key)")
+var kineticScrollArg = flag.Bool("kinetic-scroll", false, "keep scrolling with momentum after the fingers lift")
+var arbitrationArg = flag.String("arbitration", "", "who controls the mouse when several devices connect: exclusive, last-active or shared")
 var lastLog string
+var lastSecurityEvent string // always shown, unlike lastLog which needs --log
 var lastAction string
 var physicsRunning bool
 var displayUpdateChan = make(chan struct{}, 100)
@@ -325,39 +449,76 @@ func updateDisplay() {
 	// clear screen and move to top
 	fmt.Print("\033[H\033[2J")
 
-	if connectedClients {
-		fmt.Println("Status: Device connected")
-		if *logFlag {
-			fmt.Printf("Client connected: %t\n", connectedClients)
-			fmt.Printf("Physics running: %t\n", physicsRunning)
-			fmt.Printf("Last action: %s\n", lastAction)
-			if lastLog != "" {
-				fmt.Printf("Last log: %s\n", lastLog)
+	if lastSecurityEvent != "" {
+		fmt.Printf("Security: %s\n\n", lastSecurityEvent)
+	}
+
+	connected := sessions.List()
+	if len(connected) > 0 {
+		if len(connected) == 1 {
+			fmt.Println("Status: Device connected")
+		} else {
+			fmt.Printf("Status: %d devices connected (%s)\n", len(connected), sessions.Policy())
+		}
+		holder := sessions.Holder()
+		for _, session := range connected {
+			marker := " "
+			if session.ID == holder {
+				marker = "*"
+			}
+			fmt.Printf(" %s [%s] %s (%s) since %s", marker, session.ID, session.DeviceName,
+				session.RemoteAddr, session.ConnectedAt.Format("15:04:05"))
+			if session.Dropped > 0 {
+				fmt.Printf(", %d packets dropped", session.Dropped)
 			}
+			fmt.Println()
 		}
-	} else {
-		localIP := getLocalIP()
-		httpURL := fmt.Sprintf("https://%s:%d/?key=%s", localIP, *portArg, url.QueryEscape(authKey))
-
-		fmt.Print("Scan this QR code to connect:\n\n")
-		qrterminal.GenerateWithConfig(httpURL, qrterminal.Config{
-			Level:     qrterminal.L,
-			Writer:    os.Stdout,
-			BlackChar: qrterminal.BLACK,
-			WhiteChar: qrterminal.WHITE,
-			QuietZone: 0,
-		})
-		fmt.Println("\nPress Ctrl+C to exit")
 		if *logFlag {
 			fmt.Printf("Physics running: %t\n", physicsRunning)
+			fmt.Printf("Last action: %s\n", lastAction)
 			if lastLog != "" {
 				fmt.Printf("Last log: %s\n", lastLog)
 			}
 		}
+		// an exclusive session cannot be handed over, so there is no point inviting more devices
+		if sessions.Policy() == server.ArbitrationExclusive {
+			return
+		}
+		fmt.Println()
+	}
+
+	fingerprint := certManager.Fingerprint()
+	httpURL := fmt.Sprintf("https://%s/?key=%s&fp=%s", localHostPort(), url.QueryEscape(sessionKeys.Current()), fingerprint)
+
+	fmt.Print("Scan this QR code to connect:\n\n")
+	qrterminal.GenerateWithConfig(httpURL, qrterminal.Config{
+		Level:     qrterminal.L,
+		Writer:    os.Stdout,
+		BlackChar: qrterminal.BLACK,
+		WhiteChar: qrterminal.WHITE,
+		QuietZone: 0,
+	})
+	if code, expires := currentPairingCode(); code != "" {
+		fmt.Printf("\nOr open https://%s/ and enter code %s %s (expires %s)\n",
+			localHostPort(), code[:3], code[3:], expires.Format("15:04:05"))
+	}
+	fmt.Printf("\nCertificate SHA-256: %s\n", server.FormatFingerprint(fingerprint))
+	if *mdnsArg {
+		fmt.Printf("Discoverable as %s.local (%s)\n", mdnsHostName(), strings.TrimSuffix(server.MDNSServiceType, "."))
+	}
+	if *debugUIArg {
+		fmt.Printf("Debug UI: https://%s/test (asks for the key)\n", localHostPort())
+	}
+	fmt.Println("Type r and press Enter to rotate the key, Ctrl+C to exit")
+	if *logFlag && len(connected) == 0 {
+		fmt.Printf("Physics running: %t\n", physicsRunning)
+		if lastLog != "" {
+			fmt.Printf("Last log: %s\n", lastLog)
+		}
 	}
 }
 
-var authKey string
+var sessionKeys *server.SessionKeys
 
 func logIfEnabled(format string, args ...any) {
 	if *logFlag {
@@ -371,60 +532,357 @@ func logIfEnabled(format string, args ...any) {
 	}
 }
 
+// logs failed attempts and bans, and keeps the latest on screen even without --log
+func securityEvent(format string, args ...any) {
+	lastSecurityEvent = time.Now().Format("15:04:05") + " " + fmt.Sprintf(format, args...)
+	logIfEnabled(format, args...)
+}
+
+// picks the serializer matching the websocket frame type
+// text frames are json, binary frames use the compact tagged layout
+func serializerFor(messageType int) server.Serializer {
+	if messageType == websocket.BinaryMessage {
+		return binarySerializer
+	}
+	return serializer
+}
+
+// extracts the packet type from a frame without decoding the whole packet
+func readPacketType(messageType int, message []byte) (server.PacketType, error) {
+	if messageType == websocket.BinaryMessage {
+		return server.BinaryPacketType(message)
+	}
+
+	var envelope struct {
+		Type server.PacketType `json:"type"`
+	}
+	if err := json.Unmarshal(message, &envelope); err != nil {
+		return "", err
+	}
+	if envelope.Type == "" {
+		return "", fmt.Errorf("missing or invalid type field")
+	}
+	return envelope.Type, nil
+}
+
+// marshals a packet as json and sends it as a text frame. binary is only negotiated
+// for what the client sends, see CapabilityBinaryInput
+func writePacket(conn *websocket.Conn, p server.Packet) error {
+	data, err := serializer.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// reports the fingerprint of the certificate we are serving so the pairing page can
+// compare it to the one in the qr code. the browser never exposes the tls certificate
+// to javascript, so this is the closest the page can get to pinning it. with a nonce
+// the reply also carries proofs that it came from the key holder, one per key we
+// still recognize, so a page that was opened before the key rotated can check it too
+func fingerprintHandler(w http.ResponseWriter, r *http.Request) {
+	fingerprint := certManager.Fingerprint()
+	response := map[string]any{"fingerprint": fingerprint}
+
+	nonce := r.URL.Query().Get("nonce")
+	if nonce != "" {
+		var proofs []string
+		for _, key := range sessionKeys.Known() {
+			proofs = append(proofs, server.FingerprintProof(key, nonce, fingerprint))
+		}
+		// proof is the current key's alone, for pages from before proofs was added
+		response["proof"] = proofs[0]
+		response["proofs"] = proofs
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logIfEnabled("Error writing fingerprint response: %v", err)
+	}
+}
+
+// swaps a pairing code for the session key
+func pairHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	host := remoteHost(r)
+	if wait, _ := authGuard.Check(host, time.Now()); wait > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]string{"result": server.AuthResultTooManyAttempts})
+		return
+	}
+
+	var request struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&request); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	status := http.StatusOK
+	response := map[string]string{"result": server.AuthResultSuccess}
+	switch err := pairingCodes.Redeem(request.Code, time.Now()); err {
+	case nil:
+		authGuard.Success(host)
+		response["key"] = sessionKeys.Current()
+		logIfEnabled("Pairing code redeemed by %s", host)
+	case server.ErrPairingCodeExpired:
+		status = http.StatusForbidden
+		response["result"] = server.AuthResultExpiredKey
+	case server.ErrPairingTooManyAttempts:
+		authFailure(host)
+		status = http.StatusTooManyRequests
+		response["result"] = server.AuthResultTooManyAttempts
+		securityEvent("Too many wrong pairing codes, rotated code (last from %s)", host)
+	default:
+		authFailure(host)
+		status = http.StatusForbidden
+		response["result"] = server.AuthResultInvalidKey
+	}
+	// the code on screen changes whenever one is used up or rotated
+	select {
+	case displayUpdateChan <- struct{}{}:
+	default:
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// code to show in the tui, empty when pairing codes are off
+func currentPairingCode() (string, time.Time) {
+	if pairingCodes == nil {
+		return "", time.Time{}
+	}
+	code, expires := pairingCodes.Current(time.Now())
+	if len(code) != 6 {
+		return "", time.Time{}
+	}
+	return code, expires
+}
+
+// redraws the tui whenever the pairing code expires so the screen never shows a dead code
+func watchPairingCode() {
+	for {
+		_, expires := pairingCodes.Current(time.Now())
+		time.Sleep(time.Until(expires) + 10*time.Millisecond)
+		select {
+		case displayUpdateChan <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// websocket close codes matching each failed auth result
+var authCloseCodes = map[string]int{
+	server.AuthResultInvalidKey:       websocket.ClosePolicyViolation,
+	server.AuthResultExpiredKey:       websocket.ClosePolicyViolation,
+	server.AuthResultTooManyAttempts:  websocket.CloseTryAgainLater,
+	server.AuthResultProtocolMismatch: websocket.CloseProtocolError,
+}
+
+// tells the client why auth failed, then closes the socket with a matching close code
+// so it can tell a rejected key apart from the server going away. protocol 1 clients
+// do not know auth_result, they only get the close code
+func rejectAuth(conn *websocket.Conn, clientVersion int, result, message string) {
+	logIfEnabled("Rejecting client (%s): %s", result, message)
+	if clientVersion >= 2 {
+		if err := writePacket(conn, server.NewAuthResult(result, message)); err != nil {
+			logIfEnabled("Error sending auth result: %v", err)
+		}
+	}
+
+	code, exists := authCloseCodes[result]
+	if !exists {
+		code = websocket.ClosePolicyViolation
+	}
+	// close frame reasons are capped at 123 bytes, the full message is in auth_result
+	closeMessage := websocket.FormatCloseMessage(code, result)
+	if err := conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second)); err != nil {
+		logIfEnabled("Error sending close frame: %v", err)
+	}
+	conn.Close()
+}
+
+// how long a new connection gets to send its auth packet
+const authTimeout = 10 * time.Second
+
+// largest frame we accept, config packets are the biggest at a few hundred bytes
+const maxMessageSize = 4096
+
+// host part of the remote address, used to track failed attempts per machine
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// records a failed key or code from host and reports any block that results
+func authFailure(host string) {
+	wait, banned := authGuard.Failure(host, time.Now())
+	if banned {
+		securityEvent("Banned %s for %s after repeated failed attempts", host, wait)
+	} else {
+		securityEvent("Failed attempt from %s, blocked for %s", host, wait)
+	}
+}
+
 func wsHandler(w http.ResponseWriter, r *http.Request) {
+	host := remoteHost(r)
+
+	if !authGuard.BeginPending() {
+		securityEvent("Too many unauthenticated connections, refused %s", host)
+		http.Error(w, "too many pending connections", http.StatusServiceUnavailable)
+		return
+	}
+	pending := true
+	endPending := func() {
+		if pending {
+			pending = false
+			authGuard.EndPending()
+		}
+	}
+	defer endPending()
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		logIfEnabled("Error upgrading connection: %v", err)
 		return
 	}
 	defer conn.Close()
+	conn.SetReadLimit(maxMessageSize)
 
-	// require authentication first
-	_, message, err := conn.ReadMessage()
+	// require authentication first, and do not wait forever for it
+	conn.SetReadDeadline(time.Now().Add(authTimeout))
+	messageType, message, err := conn.ReadMessage()
 	if err != nil {
 		logIfEnabled("Error reading first message: %v", err)
 		return
 	}
 	logIfEnabled("Received first message: %s", message)
 
-	// always parse as auth packet
-	var envelope struct {
-		Type server.PacketType `json:"type"`
-	}
-	if err := json.Unmarshal(message, &envelope); err != nil {
-		logIfEnabled("Error parsing first JSON: %v", err)
-		conn.Close()
+	// always parse as auth packet. until it is parsed the client version is unknown,
+	// so failures up to that point are reported the protocol 1 way
+	firstType, err := readPacketType(messageType, message)
+	if err != nil {
+		rejectAuth(conn, 1, server.AuthResultProtocolMismatch, fmt.Sprintf("could not parse first message: %v", err))
 		return
 	}
-	if envelope.Type != server.Auth {
-		logIfEnabled("First message is not auth")
-		conn.Close()
+	if firstType != server.Auth {
+		rejectAuth(conn, 1, server.AuthResultProtocolMismatch, fmt.Sprintf("first message must be auth, got %s", firstType))
 		return
 	}
 
 	// unmarshal auth packet
-	packet, err := serializer.Unmarshal(message, server.Auth)
+	packet, err := serializerFor(messageType).Unmarshal(message, server.Auth)
 	if err != nil {
-		logIfEnabled("Error unmarshaling auth packet: %v", err)
-		conn.Close()
+		rejectAuth(conn, 1, server.AuthResultProtocolMismatch, fmt.Sprintf("could not parse auth packet: %v", err))
 		return
 	}
 	authPacket, ok := packet.(*server.AuthPacket)
 	if !ok {
-		logIfEnabled("Invalid auth packet")
-		conn.Close()
+		rejectAuth(conn, 1, server.AuthResultProtocolMismatch, "invalid auth packet")
 		return
 	}
-	if authPacket.Key != authKey {
-		logIfEnabled("Invalid auth key")
-		conn.Close()
+	clientVersion := server.ClientProtocolVersion(authPacket)
+
+	// checked after the auth packet so a blocked client still learns why in its own protocol
+	if wait, banned := authGuard.Check(host, time.Now()); wait > 0 {
+		if banned {
+			securityEvent("Refused banned host %s", host)
+		}
+		rejectAuth(conn, clientVersion, server.AuthResultTooManyAttempts, fmt.Sprintf("try again in %s", wait.Round(time.Second)))
 		return
 	}
 
-	logIfEnabled("Client authenticated successfully")
+	// a previously paired device can skip the qr key by presenting its device token
+	var trustedDevice *server.PairedDevice
+	if trustStore != nil {
+		if device, ok := trustStore.Verify(authPacket.DeviceToken, time.Now()); ok {
+			trustedDevice = &device
+		}
+	}
+
+	// negotiated before the key is looked at, a one-time key is burned by the check
+	protocolVersion, err := server.NegotiateProtocol(authPacket)
+	if err != nil {
+		rejectAuth(conn, clientVersion, server.AuthResultProtocolMismatch, err.Error())
+		return
+	}
+
+	if trustedDevice == nil {
+		status, rotated, err := sessionKeys.Consume(authPacket.Key, time.Now())
+		if err != nil {
+			logIfEnabled("Error rotating one-time key: %v", err)
+			return
+		}
+		if rotated {
+			logIfEnabled("One-time key used, rotated to a new key")
+		}
+		switch status {
+		case server.KeyExpired:
+			// an old qr code is an honest mistake, not worth counting against the host
+			rejectAuth(conn, clientVersion, server.AuthResultExpiredKey, "auth key has been rotated, scan the new QR code")
+			return
+		case server.KeyInvalid:
+			authFailure(host)
+			rejectAuth(conn, clientVersion, server.AuthResultInvalidKey, "invalid auth key")
+			return
+		}
+	}
+
+	authGuard.Success(host)
+	conn.SetReadDeadline(time.Time{})
+	endPending()
+
+	if protocolVersion >= 2 {
+		if err := writePacket(conn, server.NewAuthResult(server.AuthResultSuccess, "")); err != nil {
+			logIfEnabled("Error sending auth result: %v", err)
+		}
+	}
+	logIfEnabled("Client authenticated successfully (protocol %d)", protocolVersion)
 	lastAction = "auth"
 
-	connectedClients = true
+	deviceName := authPacket.DeviceName
+	if trustedDevice != nil {
+		deviceName = trustedDevice.Name
+		logIfEnabled("Trusted device %s (%s) reconnected", trustedDevice.ID, trustedDevice.Name)
+	}
+	session := sessions.Add(deviceName, r.RemoteAddr, time.Now())
+	logIfEnabled("Session %s started for %s (%s)", session.ID, session.DeviceName, session.RemoteAddr)
+
+	// protocol 1 clients do not know about auth_ok and would log it as garbage
+	if protocolVersion >= 2 {
+		authOk := controller.AuthOk(protocolVersion, server.NegotiateSerializers(authPacket))
+		authOk.SessionID = session.ID
+
+		// pair devices that came in with the qr key so they can skip it next time
+		if trustStore != nil && trustedDevice == nil {
+			token, device, err := trustStore.Pair(session.DeviceName, time.Now())
+			if err != nil {
+				logIfEnabled("Error pairing device: %v", err)
+			} else {
+				authOk.DeviceToken = token
+				logIfEnabled("Paired device %s (%s)", device.ID, device.Name)
+			}
+		}
+
+		if err := writePacket(conn, authOk); err != nil {
+			logIfEnabled("Error sending auth ok: %v", err)
+		}
+	}
+
 	// i still dont understand channels that well...
 	select {
 	case displayUpdateChan <- struct{}{}:
@@ -444,6 +902,8 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 		ButtonsAboveTouchpad: config.ButtonsAboveTouchpad,
 		NaturalScroll:        config.NaturalScroll,
 		SwapLeftRightClick:   config.SwapLeftRightClick,
+		Acceleration:         config.Acceleration,
+		Physics:              config.Physics,
 	}
 	lastAction = "config sent"
 
@@ -465,8 +925,9 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 				logIfEnabled("Panic in connection cleanup: %v", r)
 			}
 		}()
-		logIfEnabled("Connection closed, resetting client state")
-		connectedClients = false
+		logIfEnabled("Connection closed, ending session %s", session.ID)
+		sessions.Remove(session.ID)
+		controller.ReleaseKeys(session.ID)
 		select {
 		case displayUpdateChan <- struct{}{}:
 		default:
@@ -511,9 +972,11 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
+	limiter := server.NewPacketLimiter(server.DefaultPacketLimits)
+
 	// main processing loop
 	for {
-		_, message, err := conn.ReadMessage()
+		messageType, message, err := conn.ReadMessage()
 		if err != nil {
 			logIfEnabled("Error reading message: %v", err)
 			break
@@ -525,29 +988,39 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		logIfEnabled("Received: %s", message)
 
-		// parse packet type from JSON
-		if err := json.Unmarshal(message, &envelope); err != nil {
-			logIfEnabled("Error parsing JSON: %v", err)
-			continue
-		}
-		if envelope.Type == "" {
-			logIfEnabled("Missing or invalid type field")
+		// parse packet type from the JSON type field or the binary tag
+		packetType, err := readPacketType(messageType, message)
+		if err != nil {
+			logIfEnabled("Error parsing packet type: %v", err)
 			continue
 		}
-		packetType := envelope.Type
 
-		// skip auth packets if sent again
-		if packetType == server.Auth {
+		// skip auth packets if sent again, and keep-alives so they do not count as input
+		if packetType == server.Auth || packetType == server.KeepAlive {
 			continue
 		}
 
 		// unmarshal the packet
-		packet, err := serializer.Unmarshal(message, packetType)
+		packet, err := serializerFor(messageType).Unmarshal(message, packetType)
 		if err != nil {
 			logIfEnabled("Error unmarshaling packet: %v", err)
 			continue
 		}
 
+		// drop floods before they reach the mouse backend. this comes after decoding
+		// because whether a button packet is a release, which is never dropped, is
+		// only known from its contents
+		if !limiter.Allow(packet, time.Now()) {
+			dropped := limiter.TotalDropped()
+			sessions.SetDropped(session.ID, dropped)
+			// logging every drop would only add to the flood, report the first and every 100th
+			if dropped%100 == 1 {
+				securityEvent("Session %s over its %s budget, %d packets dropped so far",
+					session.ID, server.ClassifyPacket(packet), dropped)
+			}
+			continue
+		}
+
 		lastAction = string(packetType)
 
 		// handle config update packets specially here
@@ -560,34 +1033,197 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 
-			newConfig := Config{
-				LastPort:             configPacket.LastPort,
-				PointerSensitivity:   configPacket.PointerSensitivity,
-				HandheldSensitivity:  configPacket.HandheldSensitivity,
-				ScrollSensitivity:    configPacket.ScrollSensitivity,
-				ShowSensorLog:        configPacket.ShowSensorLog,
-				ButtonsAboveTouchpad: configPacket.ButtonsAboveTouchpad,
-				NaturalScroll:        configPacket.NaturalScroll,
-				SwapLeftRightClick:   configPacket.SwapLeftRightClick,
+			// start from the current config so server-only settings survive client updates
+			newConfig := getConfig()
+			newConfig.LastPort = configPacket.LastPort
+			newConfig.PointerSensitivity = configPacket.PointerSensitivity
+			newConfig.HandheldSensitivity = configPacket.HandheldSensitivity
+			newConfig.ScrollSensitivity = configPacket.ScrollSensitivity
+			newConfig.ShowSensorLog = configPacket.ShowSensorLog
+			newConfig.ButtonsAboveTouchpad = configPacket.ButtonsAboveTouchpad
+			newConfig.NaturalScroll = configPacket.NaturalScroll
+			newConfig.SwapLeftRightClick = configPacket.SwapLeftRightClick
+			if configPacket.Acceleration.Profile != "" {
+				if err := controller.SetAcceleration(configPacket.Acceleration); err != nil {
+					logIfEnabled("Ignoring acceleration from client: %v", err)
+				} else {
+					newConfig.Acceleration = configPacket.Acceleration
+				}
+			}
+			if !configPacket.Physics.Empty() {
+				// a partial update only touches the fields it sets
+				physics := configPacket.Physics.Apply(controller.Physics())
+				if err := controller.SetPhysics(physics); err != nil {
+					logIfEnabled("Ignoring physics from client: %v", err)
+				} else {
+					newConfig.Physics = physics
+				}
 			}
 			updateConfig(newConfig)
 			logIfEnabled("Configuration updated from client")
 			continue
 		}
 
-		if err := controller.ProcessPacket(packet); err != nil {
+		// only the session holding control gets to move the mouse. releases always go
+		// through without taking control, the session that pressed may have lost it
+		// since and dropping the release would leave the button down on the host
+		if server.ClassifyPacket(packet) != server.ClassRelease && !sessions.Acquire(session.ID, time.Now()) {
+			logIfEnabled("Session %s does not hold control, dropping %s", session.ID, packetType)
+			continue
+		}
+
+		if err := controller.ProcessSessionPacket(session.ID, packet); err != nil {
 			logIfEnabled("Error processing packet: %v", err)
 			continue
 		}
 	}
 }
 
-func generateAuthKey() string {
-	bytes := make([]byte, 16) // 16 bytes = 32 hex chars
-	if _, err := rand.Read(bytes); err != nil {
-		log.Fatal("Failed to generate auth key:", err)
+// swaps in a new session key and redraws the qr code
+func rotateKey(reason string) {
+	if _, err := sessionKeys.Rotate(time.Now()); err != nil {
+		logIfEnabled("Error rotating key: %v", err)
+		return
+	}
+	logIfEnabled("Rotated session key (%s)", reason)
+}
+
+// rotates the key once it expires, but only while nobody is connected so a
+// reconnecting phone does not get locked out mid session
+func watchKeyExpiry() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		if sessionKeys.Due(time.Now()) && sessions.Count() == 0 {
+			rotateKey("expired")
+		}
+	}
+}
+
+// reads commands typed into the terminal, "r" + enter rotates the key
+func readCommands() {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		switch strings.TrimSpace(strings.ToLower(scanner.Text())) {
+		case "r", "rotate":
+			rotateKey("requested")
+		}
+		// typed text scrolls the tui, so redraw either way
+		select {
+		case displayUpdateChan <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// paired device tokens (hashed) live next to config.json
+const trustStorePath = "devices.json"
+
+// handles --list-devices and --revoke-device
+func manageDevices() {
+	store, err := server.LoadTrustStore(trustStorePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *revokeDeviceArg != "" {
+		if err := store.Revoke(*revokeDeviceArg); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Revoked device %s\n", *revokeDeviceArg)
+	}
+
+	if *listDevicesArg {
+		devices := store.List()
+		if len(devices) == 0 {
+			fmt.Println("No paired devices")
+			return
+		}
+		for _, device := range devices {
+			fmt.Printf("%s  %-24s paired %s  last seen %s\n", device.ID, device.Name,
+				device.PairedAt.Format("2006-01-02 15:04"), device.LastSeen.Format("2006-01-02 15:04"))
+		}
+	}
+}
+
+// the generated certificate is cached here between runs
+const (
+	certPath = "certs/localhost.pem"
+	keyPath  = "certs/localhost-key.pem"
+)
+
+// names the phone may use to reach us, all of them go in the certificate
+func certificateHosts() []string {
+	hosts := []string{getLocalIP()}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		hosts = append(hosts, hostname)
+	}
+	if *mdnsArg {
+		hosts = append(hosts, mdnsHostName()+".local")
+	}
+	if !slices.Contains(hosts, "localhost") {
+		hosts = append(hosts, "localhost")
+	}
+	return hosts
+}
+
+// hostname squashed into a valid dns label for <name>.local
+func mdnsHostName() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "quick-mouse"
+	}
+	// some systems report the fqdn, only the first label is ours to claim
+	hostname, _, _ = strings.Cut(strings.ToLower(hostname), ".")
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+		return '-'
+	}, hostname)
+}
+
+// what the mdns responder advertises, rebuilt on every reply so it follows ip and
+// certificate changes
+func mdnsService() server.MDNSService {
+	host := mdnsHostName()
+	var ips []net.IP
+	if ip := net.ParseIP(getLocalIP()); ip != nil {
+		ips = append(ips, ip)
+	}
+	return server.MDNSService{
+		Instance: "Quick Mouse on " + host,
+		Host:     host,
+		Port:     *portArg,
+		TXT: []string{
+			fmt.Sprintf("port=%d", *portArg),
+			fmt.Sprintf("pv=%d", server.ProtocolVersion),
+			"fp=" + certManager.Fingerprint(),
+			"path=/",
+		},
+		IPs: ips,
+	}
+}
+
+// every host a page served by us could have as its origin
+func ownOriginHosts() []string {
+	return append(certificateHosts(), "127.0.0.1", "::1")
+}
+
+// regenerates the certificate when the lan ip changes, e.g. after switching networks
+func watchCertificate() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		changed, err := certManager.Ensure(certificateHosts())
+		if err != nil {
+			logIfEnabled("Error regenerating certificate: %v", err)
+			continue
+		}
+		if changed {
+			logIfEnabled("Network changed, regenerated certificate")
+		}
 	}
-	return hex.EncodeToString(bytes)
 }
 
 func main() {
@@ -617,6 +1253,20 @@ func main() {
 		log.Fatal("Port number must be between 1024 and 65533")
 	}
 
+	// device management commands run before the tui takes over the screen
+	if *listDevicesArg || *revokeDeviceArg != "" {
+		manageDevices()
+		return
+	}
+
+	if *trustDevicesArg || getConfig().TrustDevices {
+		var err error
+		trustStore, err = server.LoadTrustStore(trustStorePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	// lets not overflow the tui
 	enterAlternateScreen()
 	defer exitAlternateScreen()
@@ -631,13 +1281,57 @@ func main() {
 		os.Exit(0)
 	}()
 
-	authKey = generateAuthKey()
-	var err error
+	// cli flag overrides config file here too
+	policyName := *arbitrationArg
+	if policyName == "" {
+		policyName = getConfig().ArbitrationPolicy
+	}
+	policy, err := server.ParseArbitrationPolicy(policyName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sessions = server.NewSessionRegistry(policy)
+
+	chooseInterface()
+
+	allowedOrigins := getConfig().AllowedOrigins
+	if *allowOriginArg != "" {
+		allowedOrigins = append(allowedOrigins, strings.Split(*allowOriginArg, ",")...)
+	}
+	originPolicy = server.NewOriginPolicy(ownOriginHosts, *portArg, allowedOrigins)
+
+	if *certArg != "" || *keyArg != "" {
+		if *certArg == "" || *keyArg == "" {
+			log.Fatal("--cert and --key must be used together")
+		}
+		certManager, err = server.NewStaticCertManager(*certArg, *keyArg)
+	} else {
+		certManager, err = server.NewCertManager(certPath, keyPath, certificateHosts())
+	}
+	if err != nil {
+		log.Fatal("Failed to set up TLS certificate: ", err)
+	}
+
+	sessionKeys, err = server.NewSessionKeys(*keyTTLArg, *oneTimeKeyArg, time.Now())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *keyTTLArg > 0 {
+		go watchKeyExpiry()
+	}
 	controller, err = server.NewPacketController(*logFlag)
 	if err != nil {
 		log.Fatal("Failed to initialize packet controller:", err)
 	}
 	defer controller.Close()
+	controller.SetDoubleClickGap(time.Duration(getConfig().DoubleClickGapMs) * time.Millisecond)
+	controller.SetKineticScroll(*kineticScrollArg || getConfig().KineticScroll)
+	if err := controller.SetAcceleration(getConfig().Acceleration); err != nil {
+		log.Printf("Invalid acceleration in config.json, using none: %v", err)
+	}
+	if err := controller.SetPhysics(getConfig().Physics); err != nil {
+		log.Printf("Invalid physics in config.json, using defaults: %v", err)
+	}
 	physicsRunning = true
 
 	// Start display update goroutine
@@ -651,12 +1345,41 @@ func main() {
 	fs := http.FileServer(http.Dir("./client/build"))
 	http.Handle("/", fs)
 
-	// keep test UI available on /test route
-	http.HandleFunc("/test", landingPageHandler)
+	// the test ui can drive the mouse, so it is opt in
+	if *debugUIArg {
+		http.HandleFunc("/test", debugUIHandler)
+	}
 	http.HandleFunc("/ws", wsHandler)
+	http.HandleFunc("/fingerprint", fingerprintHandler)
+	if *pairingCodeArg {
+		pairingCodes = server.NewPairingCodes(5*time.Minute, 5)
+		http.HandleFunc("/pair", pairHandler)
+		go watchPairingCode()
+	}
+	if *mdnsArg {
+		var iface *net.Interface
+		if selectedInterface != "" {
+			iface, _ = net.InterfaceByName(selectedInterface)
+		}
+		responder := server.NewMDNSResponder(iface, mdnsService)
+		if err := responder.Start(); err != nil {
+			logIfEnabled("mDNS advertisement disabled: %v", err)
+		} else {
+			defer responder.Close()
+		}
+	}
+
 	updateDisplay()
+	go watchCertificate()
+	if stdinIsTerminal() {
+		go readCommands()
+	}
 
-	err = http.ListenAndServeTLS(fmt.Sprintf(":%d", *portArg), "certs/localhost.pem", "certs/localhost-key.pem", nil)
+	httpServer := &http.Server{
+		Addr:      net.JoinHostPort(*bindArg, strconv.Itoa(*portArg)),
+		TLSConfig: &tls.Config{GetCertificate: certManager.GetCertificate},
+	}
+	err = httpServer.ListenAndServeTLS("", "")
 	if err != nil {
 		log.Fatal("Error starting HTTPS server:", err)
 	}
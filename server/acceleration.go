@@ -0,0 +1,195 @@
+package server
+
+// pointer acceleration for the touchpad. a profile maps how fast the finger is
+// moving to a gain on top of the pointer sensitivity, so slow movements can stay
+// precise while a quick flick still crosses the screen. speeds are in touch pixels
+// per millisecond, measured over the smoothed interval between mouse_move packets.
+//
+//	flat     - no acceleration, the gain is always 1
+//	linear   - 1 up to Threshold, then rises by Slope per px/ms up to MaxFactor
+//	adaptive - like libinput: slow movements are damped for precision, then linear
+//	custom   - straight lines between the speed,factor pairs in Points
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	AccelerationFlat     = "flat"
+	AccelerationLinear   = "linear"
+	AccelerationAdaptive = "adaptive"
+	AccelerationCustom   = "custom"
+)
+
+type AccelerationConfig struct {
+	Profile   string  `json:"profile"`
+	Threshold float64 `json:"threshold"` // px/ms where acceleration kicks in
+	Slope     float64 `json:"slope"`     // gain added per px/ms over the threshold
+	MaxFactor float64 `json:"maxFactor"`
+	// custom curve as a flat list of speed,factor pairs sorted by speed
+	Points []float64 `json:"points"`
+}
+
+// the touchpad felt fine unaccelerated for years, so that stays the default
+var DefaultAccelerationConfig = AccelerationConfig{
+	Profile:   AccelerationFlat,
+	Threshold: 0.4,
+	Slope:     1.1,
+	MaxFactor: 3.0,
+}
+
+// a gap this long between mouse_move packets means the finger was lifted, not slow
+const accelerationMaxGap = 100 * time.Millisecond
+
+// the interval assumed for the first packet of a movement, one 60hz touch frame.
+// also the shortest interval a speed is measured over, wi-fi hands packets over in
+// bunches a millisecond apart and taking that literally would read as a huge flick
+const accelerationDefaultGap = 16 * time.Millisecond
+
+// weight of the newest gap in the smoothed packet interval
+const accelerationGapSmoothing = 0.3
+
+type AccelerationProfile interface {
+	// gain for a movement at speed px/ms
+	Factor(speed float64) float64
+}
+
+type FlatProfile struct{}
+
+func (FlatProfile) Factor(speed float64) float64 {
+	return 1
+}
+
+type LinearProfile struct {
+	Threshold, Slope, MaxFactor float64
+}
+
+func (p LinearProfile) Factor(speed float64) float64 {
+	if speed <= p.Threshold {
+		return 1
+	}
+	return math.Min(1+(speed-p.Threshold)*p.Slope, p.MaxFactor)
+}
+
+// follows libinput's linear profile: below the threshold the gain drops towards
+// 0.3 for very slow movement, above it grows like LinearProfile
+type AdaptiveProfile struct {
+	Threshold, Slope, MaxFactor float64
+}
+
+func (p AdaptiveProfile) Factor(speed float64) float64 {
+	slow := math.Min(1, 0.3+speed*4)
+	fast := 1 + (speed-p.Threshold)*p.Slope
+	if fast > 1 {
+		return math.Min(fast, p.MaxFactor)
+	}
+	return slow
+}
+
+type PiecewiseProfile struct {
+	Speeds  []float64
+	Factors []float64
+}
+
+func (p PiecewiseProfile) Factor(speed float64) float64 {
+	if len(p.Speeds) == 0 {
+		return 1
+	}
+	if speed <= p.Speeds[0] {
+		return p.Factors[0]
+	}
+	for i := 1; i < len(p.Speeds); i++ {
+		if speed <= p.Speeds[i] {
+			t := (speed - p.Speeds[i-1]) / (p.Speeds[i] - p.Speeds[i-1])
+			return p.Factors[i-1] + t*(p.Factors[i]-p.Factors[i-1])
+		}
+	}
+	return p.Factors[len(p.Factors)-1]
+}
+
+// builds the profile a config describes, an empty profile name means flat
+func NewAccelerationProfile(config AccelerationConfig) (AccelerationProfile, error) {
+	// a nan gain would break every move after it, and the config could no longer be saved as json
+	for name, value := range map[string]float64{
+		"threshold": config.Threshold,
+		"slope":     config.Slope,
+	} {
+		if !isFinite(value) || value < 0 {
+			return nil, fmt.Errorf("acceleration %s must be a non-negative number, got %v", name, value)
+		}
+	}
+	if !isFinite(config.MaxFactor) {
+		return nil, fmt.Errorf("acceleration maxFactor must be a number, got %v", config.MaxFactor)
+	}
+	for _, point := range config.Points {
+		if !isFinite(point) {
+			return nil, fmt.Errorf("acceleration points must be numbers, got %v", point)
+		}
+	}
+
+	if config.MaxFactor < 1 {
+		config.MaxFactor = 1
+	}
+
+	switch config.Profile {
+	case "", AccelerationFlat:
+		return FlatProfile{}, nil
+	case AccelerationLinear:
+		return LinearProfile{config.Threshold, config.Slope, config.MaxFactor}, nil
+	case AccelerationAdaptive:
+		return AdaptiveProfile{config.Threshold, config.Slope, config.MaxFactor}, nil
+	case AccelerationCustom:
+		if len(config.Points) < 2 || len(config.Points)%2 != 0 {
+			return nil, fmt.Errorf("custom acceleration needs speed,factor pairs, got %d numbers", len(config.Points))
+		}
+		var profile PiecewiseProfile
+		for i := 0; i < len(config.Points); i += 2 {
+			speed, factor := config.Points[i], config.Points[i+1]
+			if len(profile.Speeds) > 0 && speed <= profile.Speeds[len(profile.Speeds)-1] {
+				return nil, fmt.Errorf("custom acceleration speeds must be increasing")
+			}
+			if factor < 0 {
+				return nil, fmt.Errorf("custom acceleration factors cannot be negative")
+			}
+			profile.Speeds = append(profile.Speeds, speed)
+			profile.Factors = append(profile.Factors, factor)
+		}
+		return profile, nil
+	default:
+		return nil, fmt.Errorf("unknown acceleration profile %q (want flat, linear, adaptive or custom)", config.Profile)
+	}
+}
+
+// swaps the pointer acceleration profile, the old one stays if the config is invalid
+func (c *PacketController) SetAcceleration(config AccelerationConfig) error {
+	profile, err := NewAccelerationProfile(config)
+	if err != nil {
+		return err
+	}
+	c.physicsMu.Lock()
+	defer c.physicsMu.Unlock()
+	c.acceleration = profile
+	return nil
+}
+
+// gain for a touchpad movement of dx,dy arriving at now, must be called with physicsMu held
+// the speed is taken over the smoothed gap between packets rather than the last one
+// alone, so a bunched delivery averages out to the rate the finger actually moved at
+func (c *PacketController) accelerationFactor(dx, dy float64, now time.Time) float64 {
+	gap := now.Sub(c.lastMove)
+	if c.lastMove.IsZero() || gap > accelerationMaxGap {
+		c.moveGap = accelerationDefaultGap
+	} else if gap >= 0 {
+		c.moveGap += time.Duration(accelerationGapSmoothing * float64(gap-c.moveGap))
+	}
+	c.lastMove = now
+
+	if c.acceleration == nil {
+		return 1
+	}
+	interval := max(c.moveGap, accelerationDefaultGap)
+	speed := math.Hypot(dx, dy) / (float64(interval) / float64(time.Millisecond))
+	return c.acceleration.Factor(speed)
+}
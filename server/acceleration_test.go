@@ -0,0 +1,142 @@
+package server
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestAccelerationCurves(t *testing.T) {
+	linear := LinearProfile{Threshold: 0.4, Slope: 1.1, MaxFactor: 3}
+	adaptive := AdaptiveProfile{Threshold: 0.4, Slope: 1.1, MaxFactor: 3}
+	custom := PiecewiseProfile{Speeds: []float64{0.2, 1, 2}, Factors: []float64{0.5, 1, 3}}
+
+	tests := []struct {
+		name    string
+		profile AccelerationProfile
+		speed   float64
+		want    float64
+	}{
+		{"flat slow", FlatProfile{}, 0.01, 1},
+		{"flat fast", FlatProfile{}, 50, 1},
+
+		{"linear below threshold", linear, 0.2, 1},
+		{"linear at threshold", linear, 0.4, 1},
+		{"linear above threshold", linear, 1.4, 2.1},
+		{"linear capped", linear, 10, 3},
+
+		{"adaptive standing still", adaptive, 0, 0.3},
+		{"adaptive slow is damped", adaptive, 0.1, 0.7},
+		{"adaptive reaches 1", adaptive, 0.3, 1},
+		{"adaptive above threshold", adaptive, 1.4, 2.1},
+		{"adaptive capped", adaptive, 10, 3},
+
+		{"custom below first point", custom, 0, 0.5},
+		{"custom on a point", custom, 1, 1},
+		{"custom between points", custom, 0.6, 0.75},
+		{"custom between later points", custom, 1.5, 2},
+		{"custom past last point", custom, 5, 3},
+		{"custom empty", PiecewiseProfile{}, 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.profile.Factor(tt.speed); math.Abs(got-tt.want) > 1e-9 {
+				t.Fatalf("Factor(%v) = %v, want %v", tt.speed, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewAccelerationProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  AccelerationConfig
+		want    AccelerationProfile
+		wantErr bool
+	}{
+		{"empty is flat", AccelerationConfig{}, FlatProfile{}, false},
+		{"flat", AccelerationConfig{Profile: AccelerationFlat}, FlatProfile{}, false},
+		{"linear", AccelerationConfig{Profile: AccelerationLinear, Threshold: 0.4, Slope: 1.1, MaxFactor: 3}, LinearProfile{0.4, 1.1, 3}, false},
+		{"max factor below 1 is raised", AccelerationConfig{Profile: AccelerationAdaptive, MaxFactor: 0.5}, AdaptiveProfile{0, 0, 1}, false},
+		{"custom", AccelerationConfig{Profile: AccelerationCustom, Points: []float64{0, 1, 1, 2}},
+			PiecewiseProfile{Speeds: []float64{0, 1}, Factors: []float64{1, 2}}, false},
+		{"custom odd points", AccelerationConfig{Profile: AccelerationCustom, Points: []float64{0, 1, 1}}, nil, true},
+		{"custom single point", AccelerationConfig{Profile: AccelerationCustom, Points: []float64{0}}, nil, true},
+		{"custom unsorted", AccelerationConfig{Profile: AccelerationCustom, Points: []float64{1, 1, 0.5, 2}}, nil, true},
+		{"custom negative factor", AccelerationConfig{Profile: AccelerationCustom, Points: []float64{0, 1, 1, -2}}, nil, true},
+		{"unknown", AccelerationConfig{Profile: "turbo"}, nil, true},
+		{"nan threshold", AccelerationConfig{Profile: AccelerationLinear, Threshold: math.NaN(), Slope: 1, MaxFactor: 3}, nil, true},
+		{"negative slope", AccelerationConfig{Profile: AccelerationLinear, Threshold: 0.4, Slope: -1, MaxFactor: 3}, nil, true},
+		{"infinite slope", AccelerationConfig{Profile: AccelerationAdaptive, Threshold: 0.4, Slope: math.Inf(1), MaxFactor: 3}, nil, true},
+		{"infinite max factor", AccelerationConfig{Profile: AccelerationLinear, Threshold: 0.4, Slope: 1, MaxFactor: math.Inf(1)}, nil, true},
+		{"nan max factor", AccelerationConfig{Profile: AccelerationLinear, MaxFactor: math.NaN()}, nil, true},
+		{"custom nan speed", AccelerationConfig{Profile: AccelerationCustom, Points: []float64{0, 1, math.NaN(), 2}}, nil, true},
+		{"custom infinite factor", AccelerationConfig{Profile: AccelerationCustom, Points: []float64{0, 1, 1, math.Inf(1)}}, nil, true},
+		{"flat still checked", AccelerationConfig{Threshold: math.Inf(-1)}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewAccelerationProfile(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !profilesEqual(got, tt.want) {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func profilesEqual(a, b AccelerationProfile) bool {
+	pa, okA := a.(PiecewiseProfile)
+	pb, okB := b.(PiecewiseProfile)
+	if okA || okB {
+		return okA && okB && slicesEqual(pa.Speeds, pb.Speeds) && slicesEqual(pa.Factors, pb.Factors)
+	}
+	return a == b
+}
+
+func slicesEqual(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// the same finger movement has to move the pointer about as far whether the packets
+// arrive evenly or bunched up by the network
+func TestAccelerationIgnoresBunching(t *testing.T) {
+	move := func(gaps []time.Duration) int {
+		c, mouse, _, clock := newTestController(t)
+		if err := c.SetAcceleration(AccelerationConfig{Profile: AccelerationLinear, Threshold: 0.4, Slope: 1.1, MaxFactor: 3}); err != nil {
+			t.Fatal(err)
+		}
+		for _, gap := range gaps {
+			clock.Advance(gap)
+			// 8px per 16ms frame, just over the threshold
+			if err := c.ProcessPacket(&MouseMovePacket{DeltaX: 8, PointerSensitivity: 25}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		x, _ := movedBy(mouse.Events())
+		return x
+	}
+
+	var even, bunched []time.Duration
+	for range 10 {
+		even = append(even, 16*time.Millisecond, 16*time.Millisecond, 16*time.Millisecond)
+		bunched = append(bunched, 46*time.Millisecond, time.Millisecond, time.Millisecond)
+	}
+
+	evenDistance, bunchedDistance := move(even), move(bunched)
+	if ratio := float64(bunchedDistance) / float64(evenDistance); ratio > 1.1 || ratio < 0.9 {
+		t.Fatalf("bunched packets moved %dpx, evenly spaced ones %dpx", bunchedDistance, evenDistance)
+	}
+}
@@ -0,0 +1,124 @@
+package server
+
+// slows down anyone guessing keys. each remote host gets a failure counter, every
+// failure blocks the host for an exponentially growing backoff, and enough failures
+// in a row get it banned for a while. the guard also caps how many connections may
+// sit in the unauthenticated state at once, so a flood of idle sockets cannot starve
+// real clients.
+
+import (
+	"sync"
+	"time"
+)
+
+type AuthGuardConfig struct {
+	BaseBackoff time.Duration // block after the first failure, doubled for each one after
+	MaxBackoff  time.Duration
+	BanAfter    int           // failures before the host is banned outright
+	BanDuration time.Duration // also how long a quiet host's failures are remembered
+	MaxPending  int           // concurrent connections that have not authenticated yet
+}
+
+var DefaultAuthGuardConfig = AuthGuardConfig{
+	BaseBackoff: time.Second,
+	MaxBackoff:  time.Minute,
+	BanAfter:    10,
+	BanDuration: 15 * time.Minute,
+	MaxPending:  16,
+}
+
+type hostAttempts struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+	banned       bool
+}
+
+type AuthGuard struct {
+	config AuthGuardConfig
+
+	mu      sync.Mutex
+	hosts   map[string]*hostAttempts
+	pending int
+}
+
+func NewAuthGuard(config AuthGuardConfig) *AuthGuard {
+	return &AuthGuard{config: config, hosts: make(map[string]*hostAttempts)}
+}
+
+// forgets hosts that have been quiet long enough, must be called with mu held
+func (g *AuthGuard) prune(now time.Time) {
+	for host, attempts := range g.hosts {
+		if now.Sub(attempts.lastFailure) > g.config.BanDuration && !now.Before(attempts.blockedUntil) {
+			delete(g.hosts, host)
+		}
+	}
+}
+
+// reports how long host still has to wait before it may try again, and whether that
+// is because it is banned. zero means go ahead
+func (g *AuthGuard) Check(host string, now time.Time) (time.Duration, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.prune(now)
+
+	attempts, exists := g.hosts[host]
+	if !exists || !now.Before(attempts.blockedUntil) {
+		return 0, false
+	}
+	return attempts.blockedUntil.Sub(now), attempts.banned
+}
+
+// records a failed attempt and returns the resulting block and whether it is a ban
+func (g *AuthGuard) Failure(host string, now time.Time) (time.Duration, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	attempts, exists := g.hosts[host]
+	if !exists {
+		attempts = &hostAttempts{}
+		g.hosts[host] = attempts
+	}
+	attempts.failures++
+	attempts.lastFailure = now
+
+	if attempts.failures >= g.config.BanAfter {
+		attempts.banned = true
+		attempts.failures = 0
+		attempts.blockedUntil = now.Add(g.config.BanDuration)
+		return g.config.BanDuration, true
+	}
+
+	backoff := g.config.BaseBackoff << (attempts.failures - 1)
+	if backoff > g.config.MaxBackoff || backoff <= 0 {
+		backoff = g.config.MaxBackoff
+	}
+	attempts.banned = false
+	attempts.blockedUntil = now.Add(backoff)
+	return backoff, false
+}
+
+// clears the failure history of a host that got in
+func (g *AuthGuard) Success(host string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.hosts, host)
+}
+
+// claims one of the unauthenticated connection slots, false when they are all taken
+// every successful call must be paired with EndPending
+func (g *AuthGuard) BeginPending() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.pending >= g.config.MaxPending {
+		return false
+	}
+	g.pending++
+	return true
+}
+
+func (g *AuthGuard) EndPending() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pending--
+}
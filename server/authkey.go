@@ -0,0 +1,132 @@
+package server
+
+// the session key is what the qr code hands to the phone. it can be rotated on a timer
+// while nobody is connected, burned after its first use in one-time mode, or rotated by
+// hand. a few retired keys are remembered so a phone holding an old qr code is told its
+// key expired rather than that it is wrong.
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// how many retired keys are kept around to recognize as expired
+const retiredKeyHistory = 8
+
+type KeyStatus int
+
+const (
+	KeyInvalid KeyStatus = iota
+	KeyValid
+	KeyExpired
+)
+
+type SessionKeys struct {
+	ttl     time.Duration // zero means the key never expires on its own
+	oneTime bool
+
+	mu      sync.RWMutex
+	current string
+	issued  time.Time
+	retired []string
+}
+
+func NewSessionKeys(ttl time.Duration, oneTime bool, now time.Time) (*SessionKeys, error) {
+	k := &SessionKeys{ttl: ttl, oneTime: oneTime}
+	if _, err := k.Rotate(now); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *SessionKeys) Current() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current
+}
+
+// the current key followed by the retired ones, newest first
+func (k *SessionKeys) Known() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	keys := []string{k.current}
+	for i := len(k.retired) - 1; i >= 0; i-- {
+		keys = append(keys, k.retired[i])
+	}
+	return keys
+}
+
+// replaces the current key with a fresh one and returns it
+func (k *SessionKeys) Rotate(now time.Time) (string, error) {
+	key, err := randomHex(16) // 16 bytes = 32 hex chars
+	if err != nil {
+		return "", fmt.Errorf("failed to generate auth key: %v", err)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.replaceLocked(key, now)
+	return key, nil
+}
+
+// retires the current key in favor of key, must be called with mu held
+func (k *SessionKeys) replaceLocked(key string, now time.Time) {
+	if k.current != "" {
+		k.retired = append(k.retired, k.current)
+		if len(k.retired) > retiredKeyHistory {
+			k.retired = k.retired[len(k.retired)-retiredKeyHistory:]
+		}
+	}
+	k.current = key
+	k.issued = now
+}
+
+// reports whether the current key has outlived its ttl
+func (k *SessionKeys) Due(now time.Time) bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.ttl > 0 && now.Sub(k.issued) >= k.ttl
+}
+
+// compares a key from a client against the current and recently retired keys
+func (k *SessionKeys) Check(key string) KeyStatus {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.checkLocked(key)
+}
+
+func (k *SessionKeys) checkLocked(key string) KeyStatus {
+	if subtle.ConstantTimeCompare([]byte(key), []byte(k.current)) == 1 {
+		return KeyValid
+	}
+	for _, old := range k.retired {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(old)) == 1 {
+			return KeyExpired
+		}
+	}
+	return KeyInvalid
+}
+
+// checks a key a client is pairing with and, in one-time mode, burns it in the same
+// step so two connections racing with one key cannot both get in. reports whether
+// the key was rotated
+func (k *SessionKeys) Consume(key string, now time.Time) (KeyStatus, bool, error) {
+	if !k.oneTime {
+		return k.Check(key), false, nil
+	}
+	next, err := randomHex(16)
+	if err != nil {
+		return KeyInvalid, false, fmt.Errorf("failed to generate auth key: %v", err)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	status := k.checkLocked(key)
+	if status != KeyValid {
+		return status, false, nil
+	}
+	k.replaceLocked(next, now)
+	return KeyValid, true, nil
+}
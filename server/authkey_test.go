@@ -0,0 +1,96 @@
+package server
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSessionKeyRotation(t *testing.T) {
+	now := time.Unix(0, 0)
+	keys, err := NewSessionKeys(time.Minute, false, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := keys.Current()
+	if keys.Due(now.Add(59*time.Second)) || !keys.Due(now.Add(time.Minute)) {
+		t.Fatal("ttl not honored")
+	}
+
+	second, err := keys.Rotate(now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys.Check(second) != KeyValid || keys.Check(first) != KeyExpired || keys.Check("nope") != KeyInvalid {
+		t.Fatal("wrong key status after rotation")
+	}
+	if known := keys.Known(); !slices.Equal(known, []string{second, first}) {
+		t.Fatalf("known keys %v, want current then retired", known)
+	}
+}
+
+func TestSessionKeyHistoryIsBounded(t *testing.T) {
+	keys, err := NewSessionKeys(0, true, time.Unix(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := keys.Current()
+	for range retiredKeyHistory + 1 {
+		if status, rotated, err := keys.Consume(keys.Current(), time.Unix(0, 0)); err != nil || status != KeyValid || !rotated {
+			t.Fatalf("one-time key not rotated: %v %v", status, err)
+		}
+	}
+	if keys.Check(first) != KeyInvalid {
+		t.Fatal("key older than the history is still recognized")
+	}
+	if len(keys.Known()) != retiredKeyHistory+1 {
+		t.Fatalf("%d known keys, want %d", len(keys.Known()), retiredKeyHistory+1)
+	}
+}
+
+func TestOneTimeKeyWorksOnce(t *testing.T) {
+	keys, err := NewSessionKeys(0, true, time.Unix(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := keys.Current()
+
+	// many connections racing with the same key, only one may get in
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	statuses := map[KeyStatus]int{}
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, _, err := keys.Consume(key, time.Unix(0, 0))
+			if err != nil {
+				t.Error(err)
+			}
+			mu.Lock()
+			statuses[status]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if statuses[KeyValid] != 1 || statuses[KeyExpired] != 49 {
+		t.Fatalf("statuses %v, want one valid and the rest expired", statuses)
+	}
+}
+
+func TestConsumeKeepsReusableKey(t *testing.T) {
+	keys, err := NewSessionKeys(0, false, time.Unix(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := keys.Current()
+	for range 3 {
+		if status, rotated, err := keys.Consume(key, time.Unix(0, 0)); err != nil || status != KeyValid || rotated {
+			t.Fatalf("reusable key: status %v rotated %v err %v", status, rotated, err)
+		}
+	}
+	if status, _, _ := keys.Consume("nope", time.Unix(0, 0)); status != KeyInvalid {
+		t.Fatalf("wrong key gave %v", status)
+	}
+}
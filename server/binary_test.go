@@ -0,0 +1,212 @@
+package server
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// decodes data with both serializers and checks they agree, returns the json result
+func roundTrip(t *testing.T, packetType PacketType, jsonData []byte) Packet {
+	t.Helper()
+
+	fromJSON, err := JSONSerializer{}.Unmarshal(jsonData, packetType)
+	if err != nil {
+		t.Fatalf("json unmarshal: %v", err)
+	}
+	frame, err := BinarySerializer{}.Marshal(fromJSON)
+	if err != nil {
+		t.Fatalf("binary marshal: %v", err)
+	}
+	fromBinary, err := BinarySerializer{}.Unmarshal(frame, packetType)
+	if err != nil {
+		t.Fatalf("binary unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(fromJSON, fromBinary) {
+		t.Fatalf("serializers disagree\njson:   %#v\nbinary: %#v", fromJSON, fromBinary)
+	}
+	return fromJSON
+}
+
+func TestSerializersAgreeOnTemplates(t *testing.T) {
+	templates := PacketTemplates()
+	if len(templates) != len(packetRegistry) {
+		t.Fatalf("got %d templates for %d registered packets", len(templates), len(packetRegistry))
+	}
+	for _, template := range templates {
+		t.Run(string(template.Type), func(t *testing.T) {
+			if _, exists := binaryPacketTags[template.Type]; !exists {
+				t.Fatalf("no binary tag for %s", template.Type)
+			}
+			roundTrip(t, template.Type, []byte(template.JSON))
+		})
+	}
+}
+
+func TestSerializersAgreeOnValues(t *testing.T) {
+	tests := []struct {
+		packetType PacketType
+		json       string
+	}{
+		{MouseMove, `{"type":"mouse_move","x":-12,"y":7,"pointerSensitivity":31.5}`},
+		{DeviceMotion, `{"type":"device_motion","rot_alpha":359.9,"rot_beta":-45.25,"rot_gamma":12,"timestamp":1760659200123,"handheldSensitivity":5}`},
+		{ScrollMove, `{"type":"scroll_move","x":0.25,"y":-1.5,"scrollSensitivity":50}`},
+		{Auth, `{"type":"auth","key":"abc","protocolVersion":2,"minProtocolVersion":1,"serializers":["json","binary"],"capabilities":[],"deviceName":"pixel ✓","deviceToken":"tok"}`},
+		{KeyTap, `{"type":"key_tap","key":"l","modifiers":["ctrl","shift"]}`},
+		{TextInput, `{"type":"text_input","text":"héllo wörld"}`},
+		{ButtonEvent, `{"type":"button","button":"back","action":"double_click"}`},
+		{ConfigUpdate, `{"type":"config_update","lastPort":3000,"pointerSensitivity":25,"naturalScroll":true,
+			"acceleration":{"profile":"custom","points":[0,1,0.5,2]},
+			"physics":{"decay":0.002,"maxVelocity":120,"centeringForce":0,"tickRate":120}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.packetType), func(t *testing.T) {
+			roundTrip(t, tt.packetType, []byte(tt.json))
+		})
+	}
+}
+
+func TestBinaryEmptySliceIsNotNil(t *testing.T) {
+	frame, err := BinarySerializer{}.Marshal(&KeyTapPacket{Key: "a", Modifiers: []string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, err := BinarySerializer{}.Unmarshal(frame, KeyTap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modifiers := packet.(*KeyTapPacket).Modifiers; modifiers == nil || len(modifiers) != 0 {
+		t.Fatalf("want empty non-nil modifiers, got %#v", modifiers)
+	}
+}
+
+// builds a frame for packetType from a struct laid out like an older version of the packet
+func legacyFrame(t *testing.T, packetType PacketType, fields any) []byte {
+	t.Helper()
+	frame, err := appendValue([]byte{binaryPacketTags[packetType]}, reflect.ValueOf(fields), string(packetType))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func TestBinaryAuthFromProtocol2Layout(t *testing.T) {
+	// auth as it was when binary frames were introduced, before device pairing
+	frame := legacyFrame(t, Auth, struct {
+		Key                string
+		ProtocolVersion    int
+		MinProtocolVersion int
+		Serializers        []string
+		Capabilities       []string
+	}{"abc", 2, 1, []string{"json", "binary"}, []string{}})
+
+	packet, err := BinarySerializer{}.Unmarshal(frame, Auth)
+	if err != nil {
+		t.Fatalf("protocol 2 auth frame rejected: %v", err)
+	}
+	want := &AuthPacket{
+		Key:                "abc",
+		ProtocolVersion:    2,
+		MinProtocolVersion: 1,
+		Serializers:        []string{"json", "binary"},
+		Capabilities:       []string{},
+	}
+	if !reflect.DeepEqual(packet, want) {
+		t.Fatalf("got %#v, want %#v", packet, want)
+	}
+}
+
+func TestBinaryTruncatedFieldStillFails(t *testing.T) {
+	frame, err := BinarySerializer{}.Marshal(&MouseMovePacket{DeltaX: 1, DeltaY: 2, PointerSensitivity: 25})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cut into the middle of the last field
+	if _, err := (BinarySerializer{}).Unmarshal(frame[:len(frame)-3], MouseMove); err == nil {
+		t.Fatal("frame cut mid-field decoded without error")
+	}
+}
+
+func TestBinaryConfigUpdateWithoutAcceleration(t *testing.T) {
+	// config_update as older clients send it, before acceleration and physics
+	frame := legacyFrame(t, ConfigUpdate, struct {
+		PacketType           string
+		LastPort             int
+		PointerSensitivity   float64
+		HandheldSensitivity  float64
+		ScrollSensitivity    float64
+		ShowSensorLog        bool
+		ButtonsAboveTouchpad bool
+		NaturalScroll        bool
+		SwapLeftRightClick   bool
+	}{"config_update", 3000, 40, 30, 50, false, true, true, false})
+
+	packet, err := BinarySerializer{}.Unmarshal(frame, ConfigUpdate)
+	if err != nil {
+		t.Fatalf("config_update without acceleration rejected: %v", err)
+	}
+	want := &ConfigUpdatePacket{
+		PacketType:           "config_update",
+		LastPort:             3000,
+		PointerSensitivity:   40,
+		HandheldSensitivity:  30,
+		ScrollSensitivity:    50,
+		ButtonsAboveTouchpad: true,
+		NaturalScroll:        true,
+	}
+	if !reflect.DeepEqual(packet, want) {
+		t.Fatalf("got %#v, want %#v", packet, want)
+	}
+}
+
+func TestBinaryConfigUpdateWithoutPhysics(t *testing.T) {
+	// config_update from a client that knows about acceleration but not physics
+	frame := legacyFrame(t, ConfigUpdate, struct {
+		PacketType           string
+		LastPort             int
+		PointerSensitivity   float64
+		HandheldSensitivity  float64
+		ScrollSensitivity    float64
+		ShowSensorLog        bool
+		ButtonsAboveTouchpad bool
+		NaturalScroll        bool
+		SwapLeftRightClick   bool
+		Acceleration         AccelerationConfig
+	}{"config_update", 3000, 40, 30, 50, false, true, false, false, AccelerationConfig{Profile: AccelerationLinear, Threshold: 0.4, Slope: 1.1, MaxFactor: 3, Points: []float64{}}})
+
+	packet, err := BinarySerializer{}.Unmarshal(frame, ConfigUpdate)
+	if err != nil {
+		t.Fatalf("config_update without physics rejected: %v", err)
+	}
+	update := packet.(*ConfigUpdatePacket)
+	if update.Acceleration.Profile != AccelerationLinear || update.Acceleration.MaxFactor != 3 {
+		t.Fatalf("acceleration decoded as %+v", update.Acceleration)
+	}
+	if !update.Physics.Empty() {
+		t.Fatalf("missing physics decoded as %+v", update.Physics)
+	}
+}
+
+func TestBinaryRejectsNonFinite(t *testing.T) {
+	for _, value := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		frame, err := BinarySerializer{}.Marshal(&MouseMovePacket{DeltaX: 1, DeltaY: 1, PointerSensitivity: value})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := (BinarySerializer{}).Unmarshal(frame, MouseMove); err == nil {
+			t.Fatalf("mouse_move with sensitivity %v decoded", value)
+		}
+	}
+
+	frame, err := BinarySerializer{}.Marshal(&ConfigUpdatePacket{
+		PacketType:   "config_update",
+		Acceleration: AccelerationConfig{Profile: AccelerationCustom, Points: []float64{0, 1, 1, math.Inf(1)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (BinarySerializer{}).Unmarshal(frame, ConfigUpdate); err == nil {
+		t.Fatal("config_update with an infinite curve point decoded")
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+// every way a client can press a mouse button ends up here as a Button and a
+// ButtonAction. the button packet carries both directly, the older left/right click
+// packets and button_down/button_up are translated so they all behave the same.
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+type Button string
+
+const (
+	ButtonLeft    Button = "left"
+	ButtonRight   Button = "right"
+	ButtonMiddle  Button = "middle"
+	ButtonBack    Button = "back"
+	ButtonForward Button = "forward"
+)
+
+// buttons that can currently be driven over the protocol, advertised in auth_ok
+var supportedButtons = []Button{ButtonLeft, ButtonRight, ButtonMiddle, ButtonBack, ButtonForward}
+
+func ParseButton(name string) (Button, error) {
+	if button := Button(name); slices.Contains(supportedButtons, button) {
+		return button, nil
+	}
+	return "", fmt.Errorf("unknown button: %q", name)
+}
+
+type ButtonAction string
+
+const (
+	ActionDown        ButtonAction = "down"
+	ActionUp          ButtonAction = "up"
+	ActionClick       ButtonAction = "click"
+	ActionDoubleClick ButtonAction = "double_click"
+)
+
+// pause between the two clicks of a synthesized double click. it has to stay well
+// under the desktop's double click interval, which is 400-500ms almost everywhere
+const DefaultDoubleClickGap = 60 * time.Millisecond
+
+// the legacy click packets as button events
+var legacyButtonPackets = map[PacketType]struct {
+	button Button
+	action ButtonAction
+}{
+	LeftClickDown:  {ButtonLeft, ActionDown},
+	LeftClickUp:    {ButtonLeft, ActionUp},
+	RightClickDown: {ButtonRight, ActionDown},
+	RightClickUp:   {ButtonRight, ActionUp},
+}
+
+// sets how long a synthesized double click waits between its clicks
+func (c *PacketController) SetDoubleClickGap(gap time.Duration) {
+	if gap <= 0 {
+		gap = DefaultDoubleClickGap
+	}
+	c.buttonMu.Lock()
+	defer c.buttonMu.Unlock()
+	c.doubleClickGap = gap
+}
+
+// performs one button action on the mouse backend
+func (c *PacketController) pressButton(button Button, action ButtonAction) error {
+	c.logIfEnabled("Button %s %s", button, action)
+
+	switch action {
+	case ActionDown:
+		return c.mouse.Press(button)
+	case ActionUp:
+		return c.mouse.Release(button)
+	case ActionClick:
+		return c.mouse.Click(button)
+	case ActionDoubleClick:
+		c.buttonMu.Lock()
+		gap := c.doubleClickGap
+		c.buttonMu.Unlock()
+
+		if err := c.mouse.Click(button); err != nil {
+			return err
+		}
+		c.clock.Sleep(gap)
+		return c.mouse.Click(button)
+	default:
+		return fmt.Errorf("unknown button action: %q", action)
+	}
+}
@@ -0,0 +1,239 @@
+package server
+
+// the server generates its own self-signed tls certificate instead of relying on files
+// made during setup. the certificate names the lan ip and hostname the phone actually
+// connects to, and gets regenerated whenever those change or it is about to expire.
+// since nobody can vouch for a self-signed certificate, its sha-256 fingerprint is
+// shown in the tui and put in the qr code so the phone has something to check against.
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	certValidity = 365 * 24 * time.Hour
+	// regenerate a bit before expiry so a long running server never serves a dead cert
+	certRenewBefore = 7 * 24 * time.Hour
+)
+
+// hands out the current certificate and swaps it when the hosts it must cover change
+type CertManager struct {
+	certPath string
+	keyPath  string
+	managed  bool // false when the user supplied their own certificate
+
+	mu          sync.RWMutex
+	cert        *tls.Certificate
+	fingerprint string
+}
+
+// loads the self-signed certificate at certPath/keyPath, creating or regenerating it
+// so it covers hosts
+func NewCertManager(certPath, keyPath string, hosts []string) (*CertManager, error) {
+	m := &CertManager{certPath: certPath, keyPath: keyPath, managed: true}
+	if _, err := m.Ensure(hosts); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// loads a user supplied certificate as-is, it is never regenerated
+func NewStaticCertManager(certPath, keyPath string) (*CertManager, error) {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %v", err)
+	}
+	m := &CertManager{certPath: certPath, keyPath: keyPath, managed: false}
+	m.set(&cert)
+	return m, nil
+}
+
+func (m *CertManager) set(cert *tls.Certificate) {
+	sum := sha256.Sum256(cert.Certificate[0])
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cert = cert
+	m.fingerprint = hex.EncodeToString(sum[:])
+}
+
+// makes sure the certificate covers hosts and is not about to expire, regenerating it
+// if needed. reports whether the certificate changed
+func (m *CertManager) Ensure(hosts []string) (bool, error) {
+	if !m.managed {
+		return false, nil
+	}
+
+	m.mu.RLock()
+	current := m.cert
+	m.mu.RUnlock()
+
+	if current == nil {
+		if cert, err := tls.LoadX509KeyPair(m.certPath, m.keyPath); err == nil {
+			current = &cert
+		}
+	}
+	if current != nil && certCovers(current, hosts, time.Now()) {
+		m.set(current)
+		return false, nil
+	}
+
+	// the new pair is written next to the current one and only takes its place once it
+	// has loaded, the current pair is kept as .old so phones that trusted it can be
+	// pointed back at it
+	log.Printf("Generating self-signed certificate for %s", strings.Join(hosts, ", "))
+	newCertPath, newKeyPath := m.certPath+".new", m.keyPath+".new"
+	cert, err := generateCertificate(newCertPath, newKeyPath, hosts)
+	if err != nil {
+		os.Remove(newCertPath)
+		os.Remove(newKeyPath)
+		return false, err
+	}
+	if err := replaceFile(m.keyPath, newKeyPath); err != nil {
+		return false, fmt.Errorf("failed to install key: %v", err)
+	}
+	if err := replaceFile(m.certPath, newCertPath); err != nil {
+		return false, fmt.Errorf("failed to install certificate: %v", err)
+	}
+	m.set(cert)
+	return true, nil
+}
+
+// moves replacement over path, keeping whatever was at path as path.old
+func replaceFile(path, replacement string) error {
+	if err := os.Rename(path, path+".old"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Rename(replacement, path)
+}
+
+// lowercase hex sha-256 of the der encoded leaf certificate
+func (m *CertManager) Fingerprint() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.fingerprint
+}
+
+// for tls.Config.GetCertificate, so a regenerated cert is picked up without a restart
+func (m *CertManager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.cert, nil
+}
+
+// hmac-sha256 over the client nonce and the fingerprint, keyed with the pairing key.
+// lets the pairing page check the fingerprint came from someone holding the key
+// rather than from whatever happens to answer on the address
+func FingerprintProof(key, nonce, fingerprint string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(nonce + ":" + fingerprint))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// formats a hex fingerprint the way browsers show it, AB:CD:EF...
+func FormatFingerprint(fingerprint string) string {
+	var parts []string
+	for i := 0; i+2 <= len(fingerprint); i += 2 {
+		parts = append(parts, strings.ToUpper(fingerprint[i:i+2]))
+	}
+	return strings.Join(parts, ":")
+}
+
+// checks the cert is valid for a while longer and names every host
+func certCovers(cert *tls.Certificate, hosts []string, now time.Time) bool {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false
+	}
+	if now.Add(certRenewBefore).After(leaf.NotAfter) {
+		return false
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			if !slices.ContainsFunc(leaf.IPAddresses, ip.Equal) {
+				return false
+			}
+		} else if !slices.Contains(leaf.DNSNames, host) {
+			return false
+		}
+	}
+	return true
+}
+
+// creates a new self-signed certificate for hosts, writes it to disk and loads it back
+// from there so a pair that cannot be read is caught before it replaces anything
+func generateCertificate(certPath, keyPath string, hosts []string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %v", err)
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"quick-mouse"}, CommonName: hosts[0]},
+		NotBefore:             now.Add(-time.Hour), // tolerate phones with a slightly slow clock
+		NotAfter:              now.Add(certValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode key: %v", err)
+	}
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+
+	if err := os.MkdirAll(filepath.Dir(certPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create certificate directory: %v", err)
+	}
+	if err := os.WriteFile(keyPath, keyPem, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write key: %v", err)
+	}
+	if err := os.WriteFile(certPath, certPem, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write certificate: %v", err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load new certificate: %v", err)
+	}
+	return &cert, nil
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCertRegenerationKeepsOldPair(t *testing.T) {
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+
+	m, err := NewCertManager(certPath, keyPath, []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldFingerprint := m.Fingerprint()
+	oldCert, _ := os.ReadFile(certPath)
+	oldKey, _ := os.ReadFile(keyPath)
+
+	changed, err := m.Ensure([]string{"127.0.0.1", "192.168.1.20"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed || m.Fingerprint() == oldFingerprint {
+		t.Fatal("certificate was not regenerated for the new host")
+	}
+
+	for path, want := range map[string][]byte{certPath + ".old": oldCert, keyPath + ".old": oldKey} {
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("old pair not kept: %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%s does not hold the previous contents", path)
+		}
+	}
+	for _, path := range []string{certPath + ".new", keyPath + ".new"} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("%s left behind", path)
+		}
+	}
+
+	// the installed pair is the one being served
+	reloaded, err := NewCertManager(certPath, keyPath, []string{"127.0.0.1", "192.168.1.20"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Fingerprint() != m.Fingerprint() {
+		t.Fatal("pair on disk differs from the one in use")
+	}
+}
+
+func TestCertKeptWhenStillValid(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewCertManager(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), []string{"127.0.0.1", "laptop.local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed, err := m.Ensure([]string{"laptop.local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed {
+		t.Fatal("regenerated a certificate that already covers the host")
+	}
+}
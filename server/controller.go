@@ -17,20 +17,38 @@ func (c *PacketController) logIfEnabled(format string, args ...any) {
 // takes incoming packets from the websocket and translates them
 // into actual mouse stuff it acts as the bridge between network messages and system input.
 type PacketController struct {
-	mouse *UniversalMouse
+	mouse    *UniversalMouse
+	keyboard KeyboardController // nil when no keyboard backend could be created
+	clock    Clock
+
+	buttonMu       sync.Mutex
+	doubleClickGap time.Duration
+
+	// keys pressed with key_down and not released yet by session id, a session's keys
+	// are let go of when it drops
+	keysMu   sync.Mutex
+	heldKeys map[string]map[string]bool
 
 	// physics state for device motion integration
 	physicsMu   sync.RWMutex
+	physics     PhysicsParams
 	velocityX   float64
 	velocityY   float64
 	lastUpdate  time.Time
-	sensitivity float64
-	friction    float64
-	maxVelocity float64
-	rotDeadzone float64
+	scroll      scrollState
+	motion      motionState
 	isRunning   bool
 	stopPhysics chan struct{}
 
+	// pointer motion smaller than a pixel, carried over to the next move so it adds up
+	moveRemainderX float64
+	moveRemainderY float64
+
+	// touchpad acceleration, see acceleration.go
+	acceleration AccelerationProfile
+	lastMove     time.Time
+	moveGap      time.Duration // smoothed time between mouse_move packets
+
 	// calibration baselines
 	baselineRotAlpha float64
 	baselineRotBeta  float64
@@ -55,14 +73,34 @@ func NewPacketController(verbose bool) (*PacketController, error) {
 		return nil, fmt.Errorf("failed to initialize mouse: %v", err)
 	}
 
+	// typing is a nice to have, do not refuse to start over it
+	keyboard, err := NewKeyboardController()
+	if err != nil {
+		log.Printf("Keyboard input disabled: %v", err)
+		keyboard = nil
+	}
+
+	return newPacketController(mouse, keyboard, SystemClock{}, verbose), nil
+}
+
+// builds a packet controller on top of the given backends and clock instead of the
+// ones detected for this machine. keyboard may be nil. with a RecordingMouse and a
+// FakeClock nothing touches real input devices and time only moves on Advance
+func NewPacketControllerWith(mouse MouseController, keyboard KeyboardController, clock Clock, verbose bool) *PacketController {
+	return newPacketController(&UniversalMouse{controller: mouse, displayType: Unknown}, keyboard, clock, verbose)
+}
+
+func newPacketController(mouse *UniversalMouse, keyboard KeyboardController, clock Clock, verbose bool) *PacketController {
 	controller := &PacketController{
 		mouse:              mouse,
-		sensitivity:        3.0,
-		friction:           0.9,
-		maxVelocity:        150.0,
-		rotDeadzone:        2.0,
+		keyboard:           keyboard,
+		clock:              clock,
+		heldKeys:           make(map[string]map[string]bool),
+		doubleClickGap:     DefaultDoubleClickGap,
+		acceleration:       FlatProfile{},
+		physics:            DefaultPhysicsParams,
 		stopPhysics:        make(chan struct{}),
-		lastUpdate:         time.Now(),
+		lastUpdate:         clock.Now(),
 		baselineRotAlpha:   0.0,
 		baselineRotBeta:    0.0,
 		baselineRotGamma:   0.0,
@@ -76,22 +114,29 @@ func NewPacketController(verbose bool) (*PacketController, error) {
 
 	controller.startPhysicsLoop()
 
-	return controller, nil
+	return controller
 }
 
-// starts the physics integration loop that runs at 60fps
+// starts the physics integration loop at the configured tick rate
 // NOTE: we may wanna test this more later to see how far we can stretch it :)
 func (c *PacketController) startPhysicsLoop() {
 	c.isRunning = true
 	c.logIfEnabled("Starting physics loop")
 	go func() {
-		ticker := time.NewTicker(16 * time.Millisecond) // ~60fps
+		interval := c.Physics().tickInterval()
+		ticker := c.clock.NewTicker(interval)
 		defer ticker.Stop()
 
 		for {
 			select {
-			case <-ticker.C:
-				c.updatePhysics()
+			case now := <-ticker.C():
+				c.updatePhysics(now)
+				// the tick rate can be changed live through the config
+				if next := c.Physics().tickInterval(); next != interval {
+					interval = next
+					ticker.Reset(interval)
+					c.logIfEnabled("Physics loop now ticking every %s", interval)
+				}
 			case <-c.stopPhysics:
 				c.logIfEnabled("Stopping physics loop")
 				return
@@ -100,21 +145,56 @@ func (c *PacketController) startPhysicsLoop() {
 	}()
 }
 
-// updates physics state and sends mouse movements
-func (c *PacketController) updatePhysics() {
+// advances the physics to now and sends the resulting mouse movement. everything
+// is scaled by the real time since the last frame, so a late tick or a different
+// tick rate moves the cursor the same distance
+func (c *PacketController) updatePhysics(now time.Time) {
 	c.physicsMu.Lock()
 	defer c.physicsMu.Unlock()
 
-	now := time.Now()
+	// a tick that was queued behind a later frame must not wind the clock back
 	dt := now.Sub(c.lastUpdate).Seconds()
+	if dt <= 0 {
+		return
+	}
 	c.lastUpdate = now
 
 	if dt > 0.1 { // cap delta time to prevent large jumps
 		dt = 0.1
 	}
 
-	c.velocityX *= c.friction
-	c.velocityY *= c.friction
+	c.updateKineticScroll(dt)
+
+	// cap velocity
+	maxVelocity := c.physics.MaxVelocity
+	if c.velocityX > maxVelocity {
+		c.velocityX = maxVelocity
+	} else if c.velocityX < -maxVelocity {
+		c.velocityX = -maxVelocity
+	}
+	if c.velocityY > maxVelocity {
+		c.velocityY = maxVelocity
+	} else if c.velocityY < -maxVelocity {
+		c.velocityY = -maxVelocity
+	}
+
+	// the velocity decays exponentially during the frame, so the distance covered is
+	// the integral of that curve rather than velocity*dt. this is what keeps 60hz and
+	// 240hz moving the cursor exactly as far
+	decay := math.Pow(c.physics.Decay, dt)
+	travel := dt
+	if rate := -math.Log(c.physics.Decay); rate > 0 {
+		travel = (1 - decay) / rate
+	}
+
+	// convert velocity to mouse movement
+	distance := c.physics.PixelsPerSecond * travel
+	if err := c.moveBy(c.velocityX*distance, c.velocityY*distance); err != nil {
+		c.logIfEnabled("Physics mouse move error: %v", err)
+	}
+
+	c.velocityX *= decay
+	c.velocityY *= decay
 
 	// snap to zero when velocity is very small (prevents oscillation)
 	velocityThreshold := 0.01 // match low velocity threshold for consistency
@@ -124,51 +204,77 @@ func (c *PacketController) updatePhysics() {
 	if math.Abs(c.velocityY) < velocityThreshold {
 		c.velocityY = 0
 	}
-	if math.Abs(c.velocityY) < velocityThreshold {
-		c.velocityY = 0
-	}
+}
 
-	// cap velocity
-	if c.velocityX > c.maxVelocity {
-		c.velocityX = c.maxVelocity
-	} else if c.velocityX < -c.maxVelocity {
-		c.velocityX = -c.maxVelocity
+// runs one physics frame up to the controller clock's current time. the loop does
+// this on every tick, calling it directly makes a frame happen at a known moment
+func (c *PacketController) StepPhysics() {
+	c.updatePhysics(c.clock.Now())
+}
+
+// moves the pointer by a fractional number of pixels. the backends only take whole
+// pixels, so the fraction is kept and added to the next move instead of thrown away
+// must be called with physicsMu held
+func (c *PacketController) moveBy(dx, dy float64) error {
+	// a nan or inf would stick in the remainder and wreck every move after it
+	if !usableDelta(dx) || !usableDelta(dy) {
+		return fmt.Errorf("unusable mouse move (%v, %v)", dx, dy)
 	}
-	if c.velocityY > c.maxVelocity {
-		c.velocityY = c.maxVelocity
-	} else if c.velocityY < -c.maxVelocity {
-		c.velocityY = -c.maxVelocity
+	c.moveRemainderX += dx
+	c.moveRemainderY += dy
+	stepX := wholePart(c.moveRemainderX)
+	stepY := wholePart(c.moveRemainderY)
+	if stepX == 0 && stepY == 0 {
+		return nil
 	}
+	c.moveRemainderX -= stepX
+	c.moveRemainderY -= stepY
+	return c.mouse.MoveRelative(int32(stepX), int32(stepY))
+}
 
-	// convert velocity to mouse movement
-	deltaX := int32(c.velocityX * 15.0)
-	deltaY := int32(c.velocityY * 15.0)
+// the whole units in v. summing fractions like 0.3 lands a hair under the integer
+// (ten of them make 2.9999999999999996), which plain truncation would hold back
+// until the next move, so values within rounding error of the next unit count as it
+func wholePart(v float64) float64 {
+	return math.Trunc(v + math.Copysign(1e-9, v))
+}
 
-	// only move if there's meaningful velocity
-	if deltaX != 0 || deltaY != 0 {
-		err := c.mouse.MoveRelative(deltaX, deltaY)
-		if err != nil {
-			c.logIfEnabled("Physics mouse move error: %v", err)
-		}
-	}
+func isFinite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// a single move or scroll bigger than this comes from a broken client. it would not
+// fit the backends' int32 either, and nan fails the check too
+const maxInputDelta = 1 << 20
+
+func usableDelta(v float64) bool {
+	return math.Abs(v) <= maxInputDelta
 }
 
 // normalizes angle difference to -180 to 180 degrees to handle wrapping
 func normalizeAngleDiff(diff float64) float64 {
-	for diff > 180 {
-		diff -= 360
-	}
-	for diff < -180 {
-		diff += 360
-	}
-	return diff
+	return math.Remainder(diff, 360)
 }
 
-// updates velocity based on device rotation
-func (c *PacketController) updateMotion(rotAlpha, rotBeta, rotGamma float64) {
+// updates velocity based on device rotation, see motion.go for how the timestamp is used
+func (c *PacketController) updateMotion(rotAlpha, rotBeta, rotGamma float64, timestamp int64) {
+	// a nan velocity would never decay and never snap to zero
+	if !isFinite(rotAlpha) || !isFinite(rotBeta) || !isFinite(rotGamma) {
+		c.logIfEnabled("Dropped non-finite motion sample")
+		return
+	}
+
 	c.physicsMu.Lock()
 	defer c.physicsMu.Unlock()
 
+	interval, ok := c.motion.accept(timestamp, c.clock.Now())
+	if !ok {
+		c.logIfEnabled("Dropped stale or out of order motion sample (jitter %.1fms, %d dropped)", c.motion.jitter, c.motion.dropped)
+		return
+	}
+	// the forces are per 60hz sample, a sample covering more time pushes harder
+	scale := interval / motionNominalInterval
+
 	// subtract calibration baselines
 	rotAlpha -= c.baselineRotAlpha
 	rotBeta -= c.baselineRotBeta
@@ -181,27 +287,34 @@ func (c *PacketController) updateMotion(rotAlpha, rotBeta, rotGamma float64) {
 
 	// use rotBeta (pitch) for Y movement, rotGamma (roll) for X movement
 	// centering force (always applied, weak)
-	c.velocityY -= rotBeta * 0.0005
-	c.velocityX += rotGamma * 0.0005
+	c.velocityY -= rotBeta * c.physics.CenteringForce * scale
+	c.velocityX += rotGamma * c.physics.CenteringForce * scale
 	// movement force (only above deadzone)
-	if math.Abs(rotBeta) > c.rotDeadzone {
-		c.velocityY -= rotBeta * 0.01
+	if math.Abs(rotBeta) > c.physics.RotDeadzone {
+		c.velocityY -= rotBeta * c.physics.MovementGain * scale
 	}
-	if math.Abs(rotGamma) > c.rotDeadzone {
-		c.velocityX += rotGamma * 0.01
+	if math.Abs(rotGamma) > c.physics.RotDeadzone {
+		c.velocityX += rotGamma * c.physics.MovementGain * scale
 	}
 }
 
 // takes a deserialized packet and executes the corresponding mouse action
 // this is where network commands become actual cursor movements and button presses
 func (c *PacketController) ProcessPacket(packet Packet) error {
+	return c.ProcessSessionPacket("", packet)
+}
+
+// same as ProcessPacket, remembering which session pressed a key so ReleaseKeys
+// only lets go of that session's keys
+func (c *PacketController) ProcessSessionPacket(session string, packet Packet) error {
 	switch packet.Type() {
 	case MouseMove:
 		p := packet.(*MouseMovePacket)
-		sensitivity := p.PointerSensitivity / 25.0
-		scaledDeltaX := int32(float64(p.DeltaX) * sensitivity)
-		scaledDeltaY := int32(float64(p.DeltaY) * sensitivity)
-		return c.mouse.MoveRelative(scaledDeltaX, scaledDeltaY)
+		dx, dy := float64(p.DeltaX), float64(p.DeltaY)
+		c.physicsMu.Lock()
+		defer c.physicsMu.Unlock()
+		gain := p.PointerSensitivity / 25.0 * c.accelerationFactor(dx, dy, c.clock.Now())
+		return c.moveBy(dx*gain, dy*gain)
 
 	case DeviceMotion:
 		p := packet.(*DeviceMotionPacket)
@@ -209,34 +322,56 @@ func (c *PacketController) ProcessPacket(packet Packet) error {
 		scaledRotAlpha := p.RotAlpha * sensitivity
 		scaledRotBeta := p.RotBeta * sensitivity
 		scaledRotGamma := p.RotGamma * sensitivity
-		c.updateMotion(scaledRotAlpha, scaledRotBeta, scaledRotGamma)
+		c.updateMotion(scaledRotAlpha, scaledRotBeta, scaledRotGamma, p.Timestamp)
 		return nil
 
 	case ScrollMove:
 		p := packet.(*ScrollMovePacket)
 		sensitivity := p.ScrollSensitivity / 50.0
-		scaledDeltaX := int32(p.DeltaX * sensitivity)
-		scaledDeltaY := int32(p.DeltaY * sensitivity)
-		return c.mouse.Scroll(scaledDeltaX, scaledDeltaY)
+		return c.scrollSample(p.DeltaX*sensitivity, p.DeltaY*sensitivity, c.clock.Now())
 
-	case LeftClickUp:
-		c.logIfEnabled("Left click up")
-		return c.mouse.Release("left")
+	case ScrollEnd:
+		c.scrollEnd(c.clock.Now())
+		return nil
 
-	case LeftClickDown:
-		c.logIfEnabled("Left click down")
-		return c.mouse.Press("left")
+	case LeftClickUp, LeftClickDown, RightClickUp, RightClickDown:
+		legacy := legacyButtonPackets[packet.Type()]
+		return c.pressButton(legacy.button, legacy.action)
 
-	case RightClickUp:
-		c.logIfEnabled("Right click up")
-		return c.mouse.Release("right")
+	case ButtonDown:
+		p := packet.(*ButtonDownPacket)
+		button, err := ParseButton(string(p.Button))
+		if err != nil {
+			return err
+		}
+		return c.pressButton(button, ActionDown)
 
-	case RightClickDown:
-		c.logIfEnabled("Right click down")
-		return c.mouse.Press("right")
+	case ButtonUp:
+		p := packet.(*ButtonUpPacket)
+		button, err := ParseButton(string(p.Button))
+		if err != nil {
+			return err
+		}
+		return c.pressButton(button, ActionUp)
+
+	case ButtonEvent:
+		p := packet.(*ButtonPacket)
+		button, err := ParseButton(string(p.Button))
+		if err != nil {
+			return err
+		}
+		return c.pressButton(button, p.Action)
 
 	case Calibration:
 		p := packet.(*CalibrationPacket)
+		// calibration comes from the same sensor stream, so it is lined up the same way
+		c.physicsMu.Lock()
+		_, ok := c.motion.accept(p.Timestamp, c.clock.Now())
+		c.physicsMu.Unlock()
+		if !ok {
+			c.logIfEnabled("Dropped stale or out of order calibration sample")
+			return nil
+		}
 		if !c.calibrationStarted {
 			c.centerMouseForCalibration()
 			c.calibrationStarted = true
@@ -264,11 +399,92 @@ func (c *PacketController) ProcessPacket(packet Packet) error {
 		c.calibrationStarted = false
 		return nil
 
+	case KeyDown, KeyUp, KeyTap, TextInput:
+		return c.processKeyboardPacket(session, packet)
+
 	default:
 		return fmt.Errorf("unknown packet type: %s", packet.Type())
 	}
 }
 
+func (c *PacketController) processKeyboardPacket(session string, packet Packet) error {
+	if c.keyboard == nil {
+		return fmt.Errorf("keyboard input is not available on this server")
+	}
+
+	switch p := packet.(type) {
+	case *KeyDownPacket:
+		c.logIfEnabled("Key down: %s", p.Key)
+		if err := c.keyboard.KeyDown(p.Key); err != nil {
+			return err
+		}
+		c.keysMu.Lock()
+		if c.heldKeys[session] == nil {
+			c.heldKeys[session] = make(map[string]bool)
+		}
+		c.heldKeys[session][p.Key] = true
+		c.keysMu.Unlock()
+		return nil
+
+	case *KeyUpPacket:
+		c.logIfEnabled("Key up: %s", p.Key)
+		c.keysMu.Lock()
+		defer c.keysMu.Unlock()
+		delete(c.heldKeys[session], p.Key)
+		// the host only has one keyboard, another session holding the key keeps it down
+		if c.keyHeldLocked(p.Key) {
+			return nil
+		}
+		return c.keyboard.KeyUp(p.Key)
+
+	case *KeyTapPacket:
+		c.logIfEnabled("Key tap: %s %v", p.Key, p.Modifiers)
+		return c.keyboard.KeyTap(p.Key, p.Modifiers)
+
+	case *TextInputPacket:
+		// only the length, typed text can be a password
+		c.logIfEnabled("Text input: %d characters", len([]rune(p.Text)))
+		if len([]rune(p.Text)) > maxTextInputLength {
+			return fmt.Errorf("text input too long (%d characters, max %d)", len([]rune(p.Text)), maxTextInputLength)
+		}
+		return c.keyboard.TypeText(p.Text)
+
+	default:
+		return fmt.Errorf("unknown packet type: %s", packet.Type())
+	}
+}
+
+// lets go of every key the session still holds down, called when a client disconnects
+// so a dropped connection cannot leave shift stuck. keys another session is also
+// holding stay down
+func (c *PacketController) ReleaseKeys(session string) {
+	if c.keyboard == nil {
+		return
+	}
+	c.keysMu.Lock()
+	defer c.keysMu.Unlock()
+	keys := c.heldKeys[session]
+	delete(c.heldKeys, session)
+	for key := range keys {
+		if c.keyHeldLocked(key) {
+			continue
+		}
+		if err := c.keyboard.KeyUp(key); err != nil {
+			c.logIfEnabled("Failed to release key %s: %v", key, err)
+		}
+	}
+}
+
+// whether any session still holds key down, must be called with keysMu held
+func (c *PacketController) keyHeldLocked(key string) bool {
+	for _, keys := range c.heldKeys {
+		if keys[key] {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *PacketController) centerMouseForCalibration() {
 	err := c.mouse.CenterOnMainDisplay()
 	if err != nil {
@@ -287,5 +503,19 @@ func (c *PacketController) Close() error {
 		c.isRunning = false
 	}
 
+	if c.keyboard != nil {
+		c.keysMu.Lock()
+		sessions := make([]string, 0, len(c.heldKeys))
+		for session := range c.heldKeys {
+			sessions = append(sessions, session)
+		}
+		c.keysMu.Unlock()
+		for _, session := range sessions {
+			c.ReleaseKeys(session)
+		}
+		if err := c.keyboard.Close(); err != nil {
+			c.logIfEnabled("Failed to close keyboard: %v", err)
+		}
+	}
 	return c.mouse.Close()
 }
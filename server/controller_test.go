@@ -0,0 +1,401 @@
+package server
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a controller on recording backends and a clock that only moves when told to
+func newTestController(t *testing.T) (*PacketController, *RecordingMouse, *RecordingKeyboard, *FakeClock) {
+	t.Helper()
+	mouse := NewRecordingMouse()
+	keyboard := NewRecordingKeyboard()
+	clock := NewFakeClock(time.Unix(1700000000, 0))
+	c := NewPacketControllerWith(mouse, keyboard, clock, false)
+	t.Cleanup(func() { c.Close() })
+	return c, mouse, keyboard, clock
+}
+
+func TestReleaseKeysOnlyReleasesThatSession(t *testing.T) {
+	c, _, keyboard, _ := newTestController(t)
+
+	for _, step := range []struct {
+		session string
+		packet  Packet
+	}{
+		{"a", &KeyDownPacket{Key: "shift"}},
+		{"a", &KeyDownPacket{Key: "ctrl"}},
+		{"b", &KeyDownPacket{Key: "shift"}},
+		{"b", &KeyDownPacket{Key: "alt"}},
+	} {
+		if err := c.ProcessSessionPacket(step.session, step.packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keyboard.Reset()
+
+	// shift is still held by b, only ctrl comes up
+	c.ReleaseKeys("a")
+	if want := []InputEvent{{Action: "key_up", Key: "ctrl"}}; !reflect.DeepEqual(keyboard.Events(), want) {
+		t.Fatalf("releasing a sent %v, want %v", keyboard.Events(), want)
+	}
+
+	keyboard.Reset()
+	c.ReleaseKeys("b")
+	released := map[string]bool{}
+	for _, event := range keyboard.Events() {
+		released[event.Key] = event.Action == "key_up"
+	}
+	if !reflect.DeepEqual(released, map[string]bool{"shift": true, "alt": true}) {
+		t.Fatalf("releasing b sent %v", keyboard.Events())
+	}
+
+	keyboard.Reset()
+	c.ReleaseKeys("b")
+	if len(keyboard.Events()) != 0 {
+		t.Fatalf("second release sent %v", keyboard.Events())
+	}
+}
+
+func TestKeyUpKeepsKeyHeldByOtherSession(t *testing.T) {
+	c, _, keyboard, _ := newTestController(t)
+
+	c.ProcessSessionPacket("a", &KeyDownPacket{Key: "shift"})
+	c.ProcessSessionPacket("b", &KeyDownPacket{Key: "shift"})
+	keyboard.Reset()
+
+	c.ProcessSessionPacket("a", &KeyUpPacket{Key: "shift"})
+	if len(keyboard.Events()) != 0 {
+		t.Fatalf("shift released while b holds it: %v", keyboard.Events())
+	}
+	c.ProcessSessionPacket("b", &KeyUpPacket{Key: "shift"})
+	if want := []InputEvent{{Action: "key_up", Key: "shift"}}; !reflect.DeepEqual(keyboard.Events(), want) {
+		t.Fatalf("got %v, want %v", keyboard.Events(), want)
+	}
+}
+
+// sums the recorded moves
+func movedBy(events []InputEvent) (int, int) {
+	x, y := 0, 0
+	for _, event := range events {
+		if event.Action == "move" {
+			x += int(event.X)
+			y += int(event.Y)
+		}
+	}
+	return x, y
+}
+
+func TestSubPixelMovesAddUp(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []int32 // one mouse_move of dx=step, dy=-step each, every one worth 0.3px
+		want  int
+	}{
+		{"forward", repeatDelta(1, 1000), 300},
+		{"backward", repeatDelta(-1, 1000), -300},
+		{"there and back", append(repeatDelta(1, 1000), repeatDelta(-1, 1000)...), 0},
+		{"sign flips every packet", alternatingDelta(1001), 0},
+		{"flip after partial pixel", append(repeatDelta(1, 5), repeatDelta(-1, 10)...), -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, mouse, _, clock := newTestController(t)
+			for _, step := range tt.steps {
+				// 7.5/25 makes each 1px touch delta a 0.3px pointer move
+				if err := c.ProcessPacket(&MouseMovePacket{DeltaX: step, DeltaY: -step, PointerSensitivity: 7.5}); err != nil {
+					t.Fatal(err)
+				}
+				clock.Advance(8 * time.Millisecond)
+			}
+			x, y := movedBy(mouse.Events())
+			if x != tt.want || y != -tt.want {
+				t.Fatalf("moved (%d, %d), want (%d, %d)", x, y, tt.want, -tt.want)
+			}
+		})
+	}
+}
+
+func repeatDelta(delta int32, count int) []int32 {
+	steps := make([]int32, count)
+	for i := range steps {
+		steps[i] = delta
+	}
+	return steps
+}
+
+func alternatingDelta(count int) []int32 {
+	steps := make([]int32, count)
+	for i := range steps {
+		steps[i] = 1 - 2*int32(i%2)
+	}
+	// an odd count ends on a forward step, take it back so the total is zero
+	return append(steps, -1)
+}
+
+func TestFractionalScrollAddsUp(t *testing.T) {
+	c, mouse, _, clock := newTestController(t)
+	for range 1000 {
+		if err := c.ProcessPacket(&ScrollMovePacket{DeltaY: 0.3, ScrollSensitivity: 50}); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(8 * time.Millisecond)
+	}
+	notches := 0
+	for _, event := range mouse.Events() {
+		if event.Action == "scroll" {
+			notches += int(event.Y)
+		}
+	}
+	if notches != 300 {
+		t.Fatalf("scrolled %d notches, want 300", notches)
+	}
+}
+
+func TestProcessPacket(t *testing.T) {
+	tests := []struct {
+		name     string
+		packets  []Packet
+		mouse    []InputEvent
+		keyboard []InputEvent
+		check    func(t *testing.T, c *PacketController, clock *FakeClock)
+	}{
+		{
+			name:    "move",
+			packets: []Packet{&MouseMovePacket{DeltaX: 10, DeltaY: -4, PointerSensitivity: 25}},
+			mouse:   []InputEvent{{Action: "move", X: 10, Y: -4}},
+		},
+		{
+			name:    "move scaled by sensitivity",
+			packets: []Packet{&MouseMovePacket{DeltaX: 10, DeltaY: -4, PointerSensitivity: 50}},
+			mouse:   []InputEvent{{Action: "move", X: 20, Y: -8}},
+		},
+		{
+			name:    "scroll",
+			packets: []Packet{&ScrollMovePacket{DeltaX: 1, DeltaY: -3, ScrollSensitivity: 50}, &ScrollEndPacket{}},
+			mouse:   []InputEvent{{Action: "scroll", X: 1, Y: -3}},
+		},
+		{
+			name:    "scroll below a notch",
+			packets: []Packet{&ScrollMovePacket{DeltaY: 0.6, ScrollSensitivity: 50}, &ScrollMovePacket{DeltaY: 0.6, ScrollSensitivity: 50}},
+			mouse:   []InputEvent{{Action: "scroll", X: 0, Y: 1}},
+		},
+		{
+			name:    "legacy clicks",
+			packets: []Packet{&LeftClickDownPacket{}, &LeftClickUpPacket{}, &RightClickDownPacket{}, &RightClickUpPacket{}},
+			mouse: []InputEvent{
+				{Action: "press", Button: ButtonLeft}, {Action: "release", Button: ButtonLeft},
+				{Action: "press", Button: ButtonRight}, {Action: "release", Button: ButtonRight},
+			},
+		},
+		{
+			name:    "button down and up",
+			packets: []Packet{&ButtonDownPacket{Button: ButtonBack}, &ButtonUpPacket{Button: ButtonBack}},
+			mouse:   []InputEvent{{Action: "press", Button: ButtonBack}, {Action: "release", Button: ButtonBack}},
+		},
+		{
+			name: "button actions",
+			packets: []Packet{
+				&ButtonPacket{Button: ButtonMiddle, Action: ActionDown},
+				&ButtonPacket{Button: ButtonMiddle, Action: ActionUp},
+				&ButtonPacket{Button: ButtonForward, Action: ActionClick},
+			},
+			mouse: []InputEvent{
+				{Action: "press", Button: ButtonMiddle}, {Action: "release", Button: ButtonMiddle},
+				{Action: "click", Button: ButtonForward},
+			},
+		},
+		{
+			name:    "double click",
+			packets: []Packet{&ButtonPacket{Button: ButtonLeft, Action: ActionDoubleClick}},
+			mouse:   []InputEvent{{Action: "click", Button: ButtonLeft}, {Action: "click", Button: ButtonLeft}},
+			check: func(t *testing.T, c *PacketController, clock *FakeClock) {
+				if waited := clock.Now().Sub(time.Unix(1700000000, 0)); waited != DefaultDoubleClickGap {
+					t.Fatalf("waited %s between clicks, want %s", waited, DefaultDoubleClickGap)
+				}
+			},
+		},
+		{
+			name: "keys",
+			packets: []Packet{
+				&KeyDownPacket{Key: "shift"},
+				&KeyTapPacket{Key: "a", Modifiers: []string{"ctrl"}},
+				&KeyUpPacket{Key: "shift"},
+				&TextInputPacket{Text: "héllo"},
+			},
+			keyboard: []InputEvent{
+				{Action: "key_down", Key: "shift"},
+				{Action: "key_tap", Key: "a", Keys: []string{"ctrl"}},
+				{Action: "key_up", Key: "shift"},
+				{Action: "type", Text: "héllo"},
+			},
+		},
+		{
+			name: "calibration",
+			packets: []Packet{
+				&CalibrationPacket{RotAlpha: 10, RotBeta: 20, RotGamma: 30},
+				&CalibrationPacket{RotAlpha: 12, RotBeta: 22, RotGamma: 32},
+				&CalibrationDonePacket{},
+				// holding the phone where it was calibrated does not move anything
+				&DeviceMotionPacket{RotAlpha: 11, RotBeta: 21, RotGamma: 31, HandheldSensitivity: 5},
+			},
+			// centered once for the whole calibration, not once per sample
+			mouse: []InputEvent{{Action: "center"}},
+			check: func(t *testing.T, c *PacketController, clock *FakeClock) {
+				c.physicsMu.Lock()
+				defer c.physicsMu.Unlock()
+				if c.baselineRotAlpha != 11 || c.baselineRotBeta != 21 || c.baselineRotGamma != 31 {
+					t.Fatalf("baseline (%v, %v, %v), want (11, 21, 31)", c.baselineRotAlpha, c.baselineRotBeta, c.baselineRotGamma)
+				}
+				if c.velocityX != 0 || c.velocityY != 0 {
+					t.Fatalf("velocity (%v, %v) at the calibrated position", c.velocityX, c.velocityY)
+				}
+			},
+		},
+		{
+			name: "tilt after calibration",
+			packets: []Packet{
+				&CalibrationPacket{},
+				&CalibrationDonePacket{},
+				&DeviceMotionPacket{RotGamma: 10, HandheldSensitivity: 5},
+			},
+			mouse: []InputEvent{{Action: "center"}},
+			check: func(t *testing.T, c *PacketController, clock *FakeClock) {
+				c.physicsMu.Lock()
+				defer c.physicsMu.Unlock()
+				// centering and movement force both push right past the deadzone
+				want := 10 * (DefaultPhysicsParams.CenteringForce + DefaultPhysicsParams.MovementGain)
+				if math.Abs(c.velocityX-want) > 1e-12 || c.velocityY != 0 {
+					t.Fatalf("velocity (%v, %v), want (%v, 0)", c.velocityX, c.velocityY, want)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, mouse, keyboard, clock := newTestController(t)
+			for _, packet := range tt.packets {
+				if err := c.ProcessPacket(packet); err != nil {
+					t.Fatalf("%s: %v", packet.Type(), err)
+				}
+			}
+			if got := mouse.Events(); !reflect.DeepEqual(got, tt.mouse) {
+				t.Fatalf("mouse got %v, want %v", got, tt.mouse)
+			}
+			if got := keyboard.Events(); !reflect.DeepEqual(got, tt.keyboard) {
+				t.Fatalf("keyboard got %v, want %v", got, tt.keyboard)
+			}
+			if tt.check != nil {
+				tt.check(t, c, clock)
+			}
+		})
+	}
+}
+
+func TestProcessPacketRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet Packet
+	}{
+		{"unknown button", &ButtonPacket{Button: "side", Action: ActionClick}},
+		{"unknown action", &ButtonPacket{Button: ButtonLeft, Action: "hold"}},
+		{"unknown button down", &ButtonDownPacket{Button: "side"}},
+		{"text too long", &TextInputPacket{Text: strings.Repeat("a", maxTextInputLength+1)}},
+		{"not an input packet", &AuthPacket{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, mouse, keyboard, _ := newTestController(t)
+			if err := c.ProcessPacket(tt.packet); err == nil {
+				t.Fatal("accepted")
+			}
+			if len(mouse.Events()) != 0 || len(keyboard.Events()) != 0 {
+				t.Fatalf("sent %v %v", mouse.Events(), keyboard.Events())
+			}
+		})
+	}
+}
+
+// one bad packet must not leave nan in the carried fraction and break every move after it
+func TestNonFiniteMoveIsDropped(t *testing.T) {
+	for _, bad := range []Packet{
+		&MouseMovePacket{DeltaX: 3, DeltaY: 3, PointerSensitivity: math.NaN()},
+		&MouseMovePacket{DeltaX: 3, DeltaY: 3, PointerSensitivity: math.Inf(1)},
+		&MouseMovePacket{DeltaX: 3, DeltaY: 3, PointerSensitivity: math.MaxFloat64},
+		&ScrollMovePacket{DeltaY: 1, ScrollSensitivity: math.NaN()},
+		&ScrollMovePacket{DeltaY: math.Inf(-1), ScrollSensitivity: 50},
+		&DeviceMotionPacket{RotGamma: 1e300, HandheldSensitivity: 1e300},
+	} {
+		t.Run(string(bad.Type()), func(t *testing.T) {
+			c, mouse, _, clock := newTestController(t)
+			c.ProcessPacket(&MouseMovePacket{DeltaX: 1, PointerSensitivity: 12.5}) // half a pixel carried
+			c.ProcessPacket(bad)
+			mouse.Reset()
+
+			c.ProcessPacket(&MouseMovePacket{DeltaX: 1, DeltaY: 2, PointerSensitivity: 12.5})
+			c.ProcessPacket(&ScrollMovePacket{DeltaY: 1, ScrollSensitivity: 50})
+			clock.Advance(16 * time.Millisecond)
+			c.StepPhysics()
+
+			want := []InputEvent{{Action: "move", X: 1, Y: 1}, {Action: "scroll", X: 0, Y: 1}}
+			if got := mouse.Events(); !reflect.DeepEqual(got, want) {
+				t.Fatalf("after the bad packet got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestKineticScroll(t *testing.T) {
+	// scrolls the given notches with gaps before each, lifts the finger after idle and
+	// lets the momentum run out, returning how far it coasted after the lift
+	coast := func(gaps []time.Duration, notches float64, idle time.Duration) int {
+		c, mouse, _, clock := newTestController(t)
+		c.SetKineticScroll(true)
+		for _, gap := range gaps {
+			clock.Advance(gap)
+			if err := c.ProcessPacket(&ScrollMovePacket{DeltaY: notches, ScrollSensitivity: 50}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		clock.Advance(idle)
+		c.ProcessPacket(&ScrollEndPacket{})
+		mouse.Reset()
+		for range 600 {
+			clock.Advance(16 * time.Millisecond)
+			c.StepPhysics()
+		}
+		coasted := 0
+		for _, event := range mouse.Events() {
+			coasted += int(event.Y)
+		}
+		return coasted
+	}
+
+	even := coast([]time.Duration{0, 16 * time.Millisecond}, 1, 0)
+	if even == 0 {
+		t.Fatal("a flick did not coast")
+	}
+	if bunched := coast([]time.Duration{0, time.Millisecond}, 1, 0); bunched > even {
+		t.Fatalf("packets bunched 1ms apart coasted %d notches, evenly spaced ones %d", bunched, even)
+	}
+	if together := coast([]time.Duration{0, 0}, 1, 0); together > even {
+		t.Fatalf("packets arriving together coasted %d notches, evenly spaced ones %d", together, even)
+	}
+
+	// however hard the flick, the momentum tops out
+	limit := int(kineticScrollMaxVelocity/-math.Log(kineticScrollDecay)) + 1
+	if hard := coast([]time.Duration{0, 16 * time.Millisecond, 16 * time.Millisecond}, 1000, 0); hard > limit || hard <= 0 {
+		t.Fatalf("a hard flick coasted %d notches, want at most %d", hard, limit)
+	}
+
+	// lifting after the finger stopped does not coast
+	if stopped := coast([]time.Duration{0, 16 * time.Millisecond}, 1, 200*time.Millisecond); stopped != 0 {
+		t.Fatalf("coasted %d notches after the finger stopped", stopped)
+	}
+}
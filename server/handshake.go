@@ -0,0 +1,133 @@
+package server
+
+// the auth packet doubles as the protocol handshake. the client announces which
+// protocol versions and serializers it speaks, the server picks the highest version
+// both sides understand and answers with an auth_ok listing what it supports, or an
+// auth_result explaining why the session cannot continue.
+//
+// version history:
+//
+//	1 - json only, auth carries just the key
+//	2 - handshake fields on auth, auth_result/auth_ok replies, binary frames from the client
+//
+// new packet types do not need a version bump, they are announced as capabilities
+//
+// binary frames only go from the client to the server. everything the server sends
+// is json whatever was negotiated, which auth_ok spells out with binary_input
+
+import (
+	"fmt"
+	"slices"
+)
+
+const (
+	// bump this whenever packets change in a way an older peer would not understand
+	ProtocolVersion = 2
+	// oldest client protocol the server still talks to
+	MinProtocolVersion = 1
+)
+
+// serializer names used during the handshake
+const (
+	SerializerJSON   = "json"
+	SerializerBinary = "binary"
+)
+
+// capability names the server advertises in auth_ok
+const (
+	CapabilityTouchpad    = "touchpad"
+	CapabilityHandheld    = "handheld"
+	CapabilityScroll      = "scroll"
+	CapabilityCalibration = "calibration"
+	CapabilityConfigSync  = "config_sync"
+	CapabilityKeyboard    = "keyboard"
+	// the client may send binary frames, replies from the server stay json
+	CapabilityBinaryInput = "binary_input"
+)
+
+// results sent in auth_result
+const (
+	AuthResultSuccess          = "success"
+	AuthResultInvalidKey       = "invalid_key"
+	AuthResultExpiredKey       = "expired_key"
+	AuthResultTooManyAttempts  = "too_many_attempts"
+	AuthResultProtocolMismatch = "protocol_mismatch"
+)
+
+// highest protocol version the client announced
+// clients from before the handshake send no version at all, so they are treated as version 1
+func ClientProtocolVersion(p *AuthPacket) int {
+	if p.ProtocolVersion == 0 {
+		return 1
+	}
+	return p.ProtocolVersion
+}
+
+// picks the protocol version both sides speak
+func NegotiateProtocol(p *AuthPacket) (int, error) {
+	clientVersion := ClientProtocolVersion(p)
+	clientMin := p.MinProtocolVersion
+	if clientMin == 0 {
+		clientMin = clientVersion
+	}
+
+	version := min(clientVersion, ProtocolVersion)
+	if version < MinProtocolVersion || version < clientMin {
+		return 0, fmt.Errorf("client speaks protocol %d-%d, server speaks %d-%d",
+			clientMin, clientVersion, MinProtocolVersion, ProtocolVersion)
+	}
+	return version, nil
+}
+
+// returns the serializers both sides support, json is always available
+func NegotiateSerializers(p *AuthPacket) []string {
+	serializers := []string{SerializerJSON}
+	if slices.Contains(p.Serializers, SerializerBinary) {
+		serializers = append(serializers, SerializerBinary)
+	}
+	return serializers
+}
+
+// builds the auth_ok reply describing this server and its mouse backend
+func (c *PacketController) AuthOk(version int, serializers []string) AuthOkPacket {
+	capabilities := []string{
+		CapabilityTouchpad,
+		CapabilityHandheld,
+		CapabilityScroll,
+		CapabilityCalibration,
+		CapabilityConfigSync,
+	}
+	buttons := make([]string, len(supportedButtons))
+	for i, button := range supportedButtons {
+		buttons[i] = string(button)
+	}
+
+	// the keyboard is optional, the server runs without it if the backend failed
+	if c.keyboard != nil {
+		capabilities = append(capabilities, CapabilityKeyboard)
+	}
+	if slices.Contains(serializers, SerializerBinary) {
+		capabilities = append(capabilities, CapabilityBinaryInput)
+	}
+
+	return AuthOkPacket{
+		PacketType:      string(AuthOk),
+		ProtocolVersion: version,
+		Serializers:     serializers,
+		Backend:         c.mouse.Backend(),
+		Buttons:         buttons,
+		Capabilities:    capabilities,
+	}
+}
+
+// builds the auth_result reply, always carrying the server protocol range so a
+// mismatched client can tell the user which side needs updating
+func NewAuthResult(result, message string) AuthResultPacket {
+	return AuthResultPacket{
+		PacketType:         string(AuthResult),
+		Result:             result,
+		Message:            message,
+		ProtocolVersion:    ProtocolVersion,
+		MinProtocolVersion: MinProtocolVersion,
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+// picking the address to put in the qr code. laptops tend to have docker bridges, vpn
+// tunnels and vm networks lying around, and the first non-loopback address is often
+// one of those, which the phone cannot reach. virtual interfaces are skipped and the
+// remaining candidates are ordered so physical ipv4 addresses come first.
+
+import (
+	"net"
+	"slices"
+	"strings"
+)
+
+// one address the server could be reached at
+type AddressCandidate struct {
+	Interface string
+	IP        net.IP
+}
+
+// interface name prefixes for bridges, tunnels and vm networks
+var virtualInterfacePrefixes = []string{
+	"docker", "br-", "veth", "virbr", "vboxnet", "vmnet", "lxc", "lxd", "cni", "flannel",
+	"tun", "tap", "wg", "utun", "tailscale", "zt", "ppp", "ipsec", "awdl", "llw",
+}
+
+// reports whether an interface name looks like a bridge, tunnel or vm network
+func IsVirtualInterface(name string) bool {
+	lower := strings.ToLower(name)
+	for _, prefix := range virtualInterfacePrefixes {
+		if strings.HasPrefix(lower, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// lists addresses on interfaces that are up and not virtual, ipv4 before ipv6
+// link-local and loopback addresses are left out since a phone cannot use them in a url
+func ListAddressCandidates() ([]AddressCandidate, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []AddressCandidate
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || IsVirtualInterface(iface.Name) {
+			continue
+		}
+		candidates = append(candidates, InterfaceAddresses(iface)...)
+	}
+
+	slices.SortStableFunc(candidates, func(a, b AddressCandidate) int {
+		return addressRank(a.IP) - addressRank(b.IP)
+	})
+	return candidates, nil
+}
+
+// usable addresses on a single interface, regardless of whether it looks virtual, best first
+func InterfaceAddresses(iface net.Interface) []AddressCandidate {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil
+	}
+
+	var candidates []AddressCandidate
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		candidates = append(candidates, AddressCandidate{Interface: iface.Name, IP: ipnet.IP})
+	}
+	slices.SortStableFunc(candidates, func(a, b AddressCandidate) int {
+		return addressRank(a.IP) - addressRank(b.IP)
+	})
+	return candidates
+}
+
+// lower is better: private ipv4, other ipv4, then ipv6
+func addressRank(ip net.IP) int {
+	switch {
+	case ip.To4() != nil && ip.IsPrivate():
+		return 0
+	case ip.To4() != nil:
+		return 1
+	default:
+		return 2
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+// keyboard input mirrors the mouse backends: uinput on wayland, robotgo everywhere
+// else. keys are named the way robotgo names them ("enter", "f5", "a", "/") so the
+// robotgo backend can pass them through and the uinput backend maps them to codes.
+// modifiers are shift, ctrl, alt and meta (cmd on macos, super elsewhere).
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/go-vgo/robotgo"
+)
+
+// defines the interface for keyboard control backends
+type KeyboardController interface {
+	KeyDown(key string) error
+	KeyUp(key string) error
+	// presses key while holding the modifiers, then releases everything
+	KeyTap(key string, modifiers []string) error
+	// types arbitrary text, as far as the backend can express it
+	TypeText(text string) error
+	Close() error
+}
+
+// longest text_input the controller will type in one go, so a bad packet cannot
+// keep the keyboard busy for minutes
+const maxTextInputLength = 1024
+
+// keys with a name rather than a character, as robotgo spells them
+var namedKeys = []string{
+	"enter", "escape", "backspace", "tab", "space", "delete", "insert",
+	"up", "down", "left", "right", "home", "end", "pageup", "pagedown",
+	"f1", "f2", "f3", "f4", "f5", "f6", "f7", "f8", "f9", "f10", "f11", "f12",
+	"shift", "ctrl", "alt", "meta", "capslock", "printscreen",
+	"audio_mute", "audio_vol_down", "audio_vol_up", "audio_play", "audio_prev", "audio_next",
+}
+
+// normalizes a key name from a packet, single characters keep their case
+func normalizeKey(key string) string {
+	if len([]rune(key)) == 1 {
+		return key
+	}
+	key = strings.ToLower(key)
+	switch key {
+	case "esc":
+		return "escape"
+	case "return":
+		return "enter"
+	case "control":
+		return "ctrl"
+	case "cmd", "command", "super", "win":
+		return "meta"
+	case "option":
+		return "alt"
+	}
+	return key
+}
+
+// creates a keyboard controller for the detected platform
+func NewKeyboardController() (KeyboardController, error) {
+	switch displayType := DetectDisplayServer(); displayType {
+	case Wayland:
+		log.Printf("Using uinput keyboard backend")
+		return newWaylandKeyboard()
+	case X11, Windows, MacOS:
+		log.Printf("Using robotgo keyboard backend")
+		return NewRobotgoKeyboard()
+	default:
+		return nil, fmt.Errorf("unsupported display server: %s", displayType)
+	}
+}
+
+type RobotgoKeyboard struct{}
+
+func NewRobotgoKeyboard() (*RobotgoKeyboard, error) {
+	return &RobotgoKeyboard{}, nil
+}
+
+// translates a key name into robotgo's spelling
+func robotgoKey(key string) (string, error) {
+	key = normalizeKey(key)
+	if len([]rune(key)) == 1 {
+		return key, nil
+	}
+	if !slices.Contains(namedKeys, key) {
+		return "", fmt.Errorf("Unknown robotgo key %v", key)
+	}
+	if key == "meta" {
+		return "cmd", nil
+	}
+	return key, nil
+}
+
+func (k *RobotgoKeyboard) KeyDown(key string) error {
+	name, err := robotgoKey(key)
+	if err != nil {
+		return err
+	}
+	return robotgo.KeyToggle(name, "down")
+}
+
+func (k *RobotgoKeyboard) KeyUp(key string) error {
+	name, err := robotgoKey(key)
+	if err != nil {
+		return err
+	}
+	return robotgo.KeyToggle(name, "up")
+}
+
+func (k *RobotgoKeyboard) KeyTap(key string, modifiers []string) error {
+	name, err := robotgoKey(key)
+	if err != nil {
+		return err
+	}
+	args := make([]any, 0, len(modifiers))
+	for _, modifier := range modifiers {
+		modifierName, err := robotgoKey(modifier)
+		if err != nil {
+			return err
+		}
+		args = append(args, modifierName)
+	}
+	return robotgo.KeyTap(name, args...)
+}
+
+func (k *RobotgoKeyboard) TypeText(text string) error {
+	robotgo.TypeStr(text)
+	return nil
+}
+
+// close is a no-op for robotgo
+func (k *RobotgoKeyboard) Close() error {
+	return nil
+}
@@ -0,0 +1,266 @@
+//go:build linux
+
+package server
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/bendahl/uinput"
+)
+
+// uinput only knows key codes, so keys and text are mapped through a us layout.
+// characters that layout cannot produce cannot be typed on this backend
+var uinputNamedKeys = map[string]int{
+	"enter":          uinput.KeyEnter,
+	"escape":         uinput.KeyEsc,
+	"backspace":      uinput.KeyBackspace,
+	"tab":            uinput.KeyTab,
+	"space":          uinput.KeySpace,
+	"delete":         uinput.KeyDelete,
+	"insert":         uinput.KeyInsert,
+	"up":             uinput.KeyUp,
+	"down":           uinput.KeyDown,
+	"left":           uinput.KeyLeft,
+	"right":          uinput.KeyRight,
+	"home":           uinput.KeyHome,
+	"end":            uinput.KeyEnd,
+	"pageup":         uinput.KeyPageup,
+	"pagedown":       uinput.KeyPagedown,
+	"f1":             uinput.KeyF1,
+	"f2":             uinput.KeyF2,
+	"f3":             uinput.KeyF3,
+	"f4":             uinput.KeyF4,
+	"f5":             uinput.KeyF5,
+	"f6":             uinput.KeyF6,
+	"f7":             uinput.KeyF7,
+	"f8":             uinput.KeyF8,
+	"f9":             uinput.KeyF9,
+	"f10":            uinput.KeyF10,
+	"f11":            uinput.KeyF11,
+	"f12":            uinput.KeyF12,
+	"shift":          uinput.KeyLeftshift,
+	"ctrl":           uinput.KeyLeftctrl,
+	"alt":            uinput.KeyLeftalt,
+	"meta":           uinput.KeyLeftmeta,
+	"capslock":       uinput.KeyCapslock,
+	"printscreen":    uinput.KeySysrq,
+	"audio_mute":     uinput.KeyMute,
+	"audio_vol_down": uinput.KeyVolumedown,
+	"audio_vol_up":   uinput.KeyVolumeup,
+	"audio_play":     uinput.KeyPlaypause,
+	"audio_prev":     uinput.KeyPrevioussong,
+	"audio_next":     uinput.KeyNextsong,
+}
+
+type uinputChar struct {
+	code  int
+	shift bool
+}
+
+var uinputChars = func() map[rune]uinputChar {
+	chars := map[rune]uinputChar{
+		' ': {uinput.KeySpace, false}, '\n': {uinput.KeyEnter, false}, '\t': {uinput.KeyTab, false},
+		'-': {uinput.KeyMinus, false}, '_': {uinput.KeyMinus, true},
+		'=': {uinput.KeyEqual, false}, '+': {uinput.KeyEqual, true},
+		'[': {uinput.KeyLeftbrace, false}, '{': {uinput.KeyLeftbrace, true},
+		']': {uinput.KeyRightbrace, false}, '}': {uinput.KeyRightbrace, true},
+		';': {uinput.KeySemicolon, false}, ':': {uinput.KeySemicolon, true},
+		'\'': {uinput.KeyApostrophe, false}, '"': {uinput.KeyApostrophe, true},
+		'`': {uinput.KeyGrave, false}, '~': {uinput.KeyGrave, true},
+		'\\': {uinput.KeyBackslash, false}, '|': {uinput.KeyBackslash, true},
+		',': {uinput.KeyComma, false}, '<': {uinput.KeyComma, true},
+		'.': {uinput.KeyDot, false}, '>': {uinput.KeyDot, true},
+		'/': {uinput.KeySlash, false}, '?': {uinput.KeySlash, true},
+	}
+
+	letters := []int{
+		uinput.KeyA, uinput.KeyB, uinput.KeyC, uinput.KeyD, uinput.KeyE, uinput.KeyF, uinput.KeyG,
+		uinput.KeyH, uinput.KeyI, uinput.KeyJ, uinput.KeyK, uinput.KeyL, uinput.KeyM, uinput.KeyN,
+		uinput.KeyO, uinput.KeyP, uinput.KeyQ, uinput.KeyR, uinput.KeyS, uinput.KeyT, uinput.KeyU,
+		uinput.KeyV, uinput.KeyW, uinput.KeyX, uinput.KeyY, uinput.KeyZ,
+	}
+	for i, code := range letters {
+		chars['a'+rune(i)] = uinputChar{code, false}
+		chars['A'+rune(i)] = uinputChar{code, true}
+	}
+
+	// digits run 1-9 then 0 on the keyboard, shifted they give the symbols above them
+	digits := []int{
+		uinput.Key0, uinput.Key1, uinput.Key2, uinput.Key3, uinput.Key4,
+		uinput.Key5, uinput.Key6, uinput.Key7, uinput.Key8, uinput.Key9,
+	}
+	symbols := []rune(")!@#$%^&*(")
+	for i, code := range digits {
+		chars['0'+rune(i)] = uinputChar{code, false}
+		chars[symbols[i]] = uinputChar{code, true}
+	}
+	return chars
+}()
+
+type WaylandKeyboard struct {
+	device uinput.Keyboard
+
+	mu sync.Mutex
+	// the keys holding each code down. a shifted character like "A" holds shift as
+	// well, so shift only comes up once neither it nor any shifted key still needs it.
+	// taps hold their modifiers under the empty name
+	holders map[int]map[string]bool
+}
+
+func newWaylandKeyboard() (KeyboardController, error) {
+	keyboard, err := uinput.CreateKeyboard("/dev/uinput", []byte("virtual-keyboard"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create uinput keyboard: %v", err)
+	}
+	return &WaylandKeyboard{device: keyboard, holders: make(map[int]map[string]bool)}, nil
+}
+
+// looks up the code for a key and whether shift has to be held for it
+func uinputKey(key string) (uinputChar, error) {
+	key = normalizeKey(key)
+	if code, exists := uinputNamedKeys[key]; exists {
+		return uinputChar{code, false}, nil
+	}
+	if runes := []rune(key); len(runes) == 1 {
+		if char, exists := uinputChars[runes[0]]; exists {
+			return char, nil
+		}
+	}
+	return uinputChar{}, fmt.Errorf("Unknown wayland key %v", key)
+}
+
+// the codes a key presses, shift first when the character needs it
+func (c uinputChar) codes() []int {
+	if c.shift {
+		return []int{uinput.KeyLeftshift, c.code}
+	}
+	return []int{c.code}
+}
+
+func (k *WaylandKeyboard) KeyDown(key string) error {
+	char, err := uinputKey(key)
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, code := range char.codes() {
+		if err := k.hold(code, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (k *WaylandKeyboard) KeyUp(key string) error {
+	char, err := uinputKey(key)
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	codes := char.codes()
+	for i := len(codes) - 1; i >= 0; i-- {
+		if releaseErr := k.release(codes[i], key); releaseErr != nil && err == nil {
+			err = releaseErr
+		}
+	}
+	return err
+}
+
+// marks code as held by key, pressing it if nothing held it yet. must be called with mu held
+func (k *WaylandKeyboard) hold(code int, key string) error {
+	if len(k.holders[code]) == 0 {
+		if err := k.device.KeyDown(code); err != nil {
+			return err
+		}
+		k.holders[code] = make(map[string]bool)
+	}
+	k.holders[code][key] = true
+	return nil
+}
+
+// drops key's hold on code, letting go of it once nothing holds it. a release for a
+// key nobody is holding still goes out, so a stuck key can always be lifted.
+// must be called with mu held
+func (k *WaylandKeyboard) release(code int, key string) error {
+	delete(k.holders[code], key)
+	if len(k.holders[code]) > 0 {
+		return nil
+	}
+	delete(k.holders, code)
+	return k.device.KeyUp(code)
+}
+
+func (k *WaylandKeyboard) KeyTap(key string, modifiers []string) error {
+	char, err := uinputKey(key)
+	if err != nil {
+		return err
+	}
+
+	codes := make([]int, 0, len(modifiers)+1)
+	for _, modifier := range modifiers {
+		modifierChar, err := uinputKey(modifier)
+		if err != nil {
+			return err
+		}
+		codes = append(codes, modifierChar.code)
+	}
+	if char.shift {
+		codes = append(codes, uinput.KeyLeftshift)
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.tap(char.code, codes)
+}
+
+// presses code with the held keys down, releasing them in reverse order even on error.
+// keys something else is holding stay down. must be called with mu held
+func (k *WaylandKeyboard) tap(code int, held []int) error {
+	pressed := 0
+	var err error
+	for _, heldCode := range held {
+		if err = k.hold(heldCode, ""); err != nil {
+			break
+		}
+		pressed++
+	}
+	if err == nil {
+		err = k.device.KeyPress(code)
+	}
+	for i := pressed - 1; i >= 0; i-- {
+		if releaseErr := k.release(held[i], ""); releaseErr != nil && err == nil {
+			err = releaseErr
+		}
+	}
+	return err
+}
+
+func (k *WaylandKeyboard) TypeText(text string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	var unsupported []rune
+	for _, r := range text {
+		char, exists := uinputChars[r]
+		if !exists {
+			unsupported = append(unsupported, r)
+			continue
+		}
+		var held []int
+		if char.shift {
+			held = []int{uinput.KeyLeftshift}
+		}
+		if err := k.tap(char.code, held); err != nil {
+			return err
+		}
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("wayland keyboard cannot type %q, only us ascii is supported", string(unsupported))
+	}
+	return nil
+}
+
+func (k *WaylandKeyboard) Close() error {
+	return k.device.Close()
+}
@@ -0,0 +1,97 @@
+//go:build linux
+
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/bendahl/uinput"
+)
+
+// a uinput keyboard that writes down the key codes it was sent
+type recordingUinputKeyboard struct {
+	events []string
+}
+
+func (k *recordingUinputKeyboard) KeyPress(key int) error {
+	k.events = append(k.events, fmt.Sprintf("press %d", key))
+	return nil
+}
+
+func (k *recordingUinputKeyboard) KeyDown(key int) error {
+	k.events = append(k.events, fmt.Sprintf("down %d", key))
+	return nil
+}
+
+func (k *recordingUinputKeyboard) KeyUp(key int) error {
+	k.events = append(k.events, fmt.Sprintf("up %d", key))
+	return nil
+}
+
+func (k *recordingUinputKeyboard) FetchSyspath() (string, error) { return "", nil }
+func (k *recordingUinputKeyboard) Close() error                  { return nil }
+
+func TestWaylandKeyboardHoldsShiftForShiftedKeys(t *testing.T) {
+	shift, a, one := uinput.KeyLeftshift, uinput.KeyA, uinput.Key1
+	down := func(code int) string { return fmt.Sprintf("down %d", code) }
+	up := func(code int) string { return fmt.Sprintf("up %d", code) }
+	press := func(code int) string { return fmt.Sprintf("press %d", code) }
+
+	type step struct {
+		down bool
+		key  string
+	}
+	tests := []struct {
+		name  string
+		steps []step
+		want  []string
+	}{
+		{"plain key", []step{{true, "a"}, {false, "a"}}, []string{down(a), up(a)}},
+		{"capital letter", []step{{true, "A"}, {false, "A"}}, []string{down(shift), down(a), up(a), up(shift)}},
+		{"shifted symbol", []step{{true, "!"}, {false, "!"}}, []string{down(shift), down(one), up(one), up(shift)}},
+		{
+			"shift held on its own outlives a shifted key",
+			[]step{{true, "shift"}, {true, "A"}, {false, "A"}, {false, "shift"}},
+			[]string{down(shift), down(a), up(a), up(shift)},
+		},
+		{
+			"shifted key outlives shift held on its own",
+			[]step{{true, "A"}, {true, "shift"}, {false, "shift"}, {false, "A"}},
+			[]string{down(shift), down(a), up(a), up(shift)},
+		},
+		{"repeated key down", []step{{true, "A"}, {true, "A"}, {false, "A"}}, []string{down(shift), down(a), up(a), up(shift)}},
+		{"release of a key nobody holds", []step{{false, "A"}}, []string{up(a), up(shift)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device := &recordingUinputKeyboard{}
+			k := &WaylandKeyboard{device: device, holders: make(map[int]map[string]bool)}
+			for _, s := range tt.steps {
+				var err error
+				if s.down {
+					err = k.KeyDown(s.key)
+				} else {
+					err = k.KeyUp(s.key)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !reflect.DeepEqual(device.events, tt.want) {
+				t.Fatalf("sent %v, want %v", device.events, tt.want)
+			}
+		})
+	}
+
+	// a tap needing shift leaves a shift the user is holding down
+	device := &recordingUinputKeyboard{}
+	k := &WaylandKeyboard{device: device, holders: make(map[int]map[string]bool)}
+	k.KeyDown("shift")
+	k.KeyTap("A", nil)
+	if want := []string{down(shift), press(a)}; !reflect.DeepEqual(device.events, want) {
+		t.Fatalf("tap while holding shift sent %v, want %v", device.events, want)
+	}
+}
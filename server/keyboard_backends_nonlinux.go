@@ -0,0 +1,9 @@
+//go:build !linux
+
+package server
+
+import "fmt"
+
+func newWaylandKeyboard() (KeyboardController, error) {
+	return nil, fmt.Errorf("Wayland backend not supported on this platform")
+}
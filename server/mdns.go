@@ -0,0 +1,415 @@
+package server
+
+// a small multicast dns responder so the server shows up as a _quickmouse._tcp dns-sd
+// service on the lan. it only answers questions about our own records, there is no
+// cache or conflict resolution, which is plenty for one service per machine. the dns
+// wire format is handled by hand to avoid pulling in a dependency for ~5 record types.
+//
+// records served, with "Quick Mouse on host" as the instance name:
+//
+//	_services._dns-sd._udp.local  PTR  _quickmouse._tcp.local
+//	_quickmouse._tcp.local        PTR  Quick Mouse on host._quickmouse._tcp.local
+//	Quick Mouse on host...        SRV  0 0 <port> host.local
+//	Quick Mouse on host...        TXT  port=... pv=... fp=...
+//	host.local                    A / AAAA
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MDNSServiceType = "_quickmouse._tcp.local."
+	mdnsServiceEnum = "_services._dns-sd._udp.local."
+	mdnsPort        = 5353
+	mdnsTTL         = 120
+
+	dnsTypeA    = 1
+	dnsTypePTR  = 12
+	dnsTypeTXT  = 16
+	dnsTypeAAAA = 28
+	dnsTypeSRV  = 33
+	dnsTypeANY  = 255
+
+	dnsClassIN         = 1
+	dnsClassCacheFlush = 0x8000 // on answers: this record replaces any cached copies
+	dnsClassUnicast    = 0x8000 // on questions: the asker wants a unicast reply
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: mdnsPort}
+
+// what gets advertised, asked for on every reply so ip or fingerprint changes show up
+type MDNSService struct {
+	Instance string   // human readable instance name, e.g. "Quick Mouse on desk"
+	Host     string   // host name without .local
+	Port     int      // https port
+	TXT      []string // key=value pairs
+	IPs      []net.IP
+}
+
+type MDNSResponder struct {
+	service func() MDNSService
+	iface   *net.Interface
+
+	mu     sync.Mutex
+	conn   *net.UDPConn
+	closed bool
+}
+
+// creates a responder on iface, nil means the system default multicast interface
+func NewMDNSResponder(iface *net.Interface, service func() MDNSService) *MDNSResponder {
+	return &MDNSResponder{service: service, iface: iface}
+}
+
+// joins the mdns group, announces the service and starts answering queries
+func (r *MDNSResponder) Start() error {
+	conn, err := net.ListenMulticastUDP("udp4", r.iface, mdnsGroup)
+	if err != nil {
+		return fmt.Errorf("failed to join mdns group: %v", err)
+	}
+	r.mu.Lock()
+	r.conn = conn
+	r.mu.Unlock()
+
+	go r.serve()
+	go r.announce()
+	return nil
+}
+
+// sends a goodbye so browsers drop us right away, then stops answering
+func (r *MDNSResponder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil || r.closed {
+		return nil
+	}
+	r.closed = true
+
+	if goodbye, err := buildMDNSResponse(r.service(), 0, nil, 0); err == nil {
+		r.conn.WriteToUDP(goodbye, mdnsGroup)
+	}
+	return r.conn.Close()
+}
+
+// unsolicited announcements as rfc 6762 section 8.3 asks, twice a second apart
+func (r *MDNSResponder) announce() {
+	for i := range 2 {
+		if i > 0 {
+			time.Sleep(time.Second)
+		}
+		message, err := buildMDNSResponse(r.service(), 0, nil, mdnsTTL)
+		if err != nil {
+			log.Printf("mdns: failed to build announcement: %v", err)
+			return
+		}
+		if err := r.write(message, mdnsGroup); err != nil {
+			return
+		}
+	}
+}
+
+func (r *MDNSResponder) write(message []byte, to *net.UDPAddr) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return net.ErrClosed
+	}
+	_, err := r.conn.WriteToUDP(message, to)
+	return err
+}
+
+func (r *MDNSResponder) serve() {
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		response, unicast, ok := r.HandleQuery(buf[:n], from.Port != mdnsPort)
+		if !ok {
+			continue
+		}
+		to := mdnsGroup
+		if unicast {
+			to = from
+		}
+		r.write(response, to)
+	}
+}
+
+// answers a raw mdns query. legacy is true for one-shot resolvers that did not send
+// from port 5353, those get a unicast reply that echoes the query id and questions.
+// returns the response, whether it should be sent unicast, and false when the query
+// had nothing to do with us
+func (r *MDNSResponder) HandleQuery(query []byte, legacy bool) ([]byte, bool, bool) {
+	if len(query) < 12 {
+		return nil, false, false
+	}
+	id := binary.BigEndian.Uint16(query[0:2])
+	flags := binary.BigEndian.Uint16(query[2:4])
+	if flags&0x8000 != 0 { // a response from someone else
+		return nil, false, false
+	}
+
+	questions, err := parseDNSQuestions(query, int(binary.BigEndian.Uint16(query[4:6])))
+	if err != nil || len(questions) == 0 {
+		return nil, false, false
+	}
+
+	service := r.service()
+	var matched []dnsQuestion
+	unicast := legacy
+	for _, q := range questions {
+		if len(serviceRecords(service, q.name, q.qtype, mdnsTTL)) > 0 {
+			matched = append(matched, q)
+			if q.qclass&dnsClassUnicast != 0 {
+				unicast = true
+			}
+		}
+	}
+	if len(matched) == 0 {
+		return nil, false, false
+	}
+
+	if !legacy {
+		id = 0
+	}
+	response, err := buildMDNSResponse(service, id, matched, mdnsTTL)
+	if err != nil {
+		return nil, false, false
+	}
+	return response, unicast, true
+}
+
+type dnsQuestion struct {
+	name   string
+	qtype  uint16
+	qclass uint16
+}
+
+type dnsRecord struct {
+	name  string
+	rtype uint16
+	flush bool // unique records get the cache flush bit, shared ones like PTR do not
+	ttl   uint32
+	data  []byte
+}
+
+func (s MDNSService) instanceName() string {
+	return escapeDNSLabel(s.Instance) + "." + MDNSServiceType
+}
+
+func (s MDNSService) hostName() string {
+	return s.Host + ".local."
+}
+
+// records answering a question, empty when the name is not ours
+func serviceRecords(s MDNSService, name string, qtype uint16, ttl uint32) []dnsRecord {
+	matches := func(t uint16) bool { return qtype == t || qtype == dnsTypeANY }
+	var records []dnsRecord
+
+	switch {
+	case strings.EqualFold(name, mdnsServiceEnum):
+		if matches(dnsTypePTR) {
+			records = append(records, dnsRecord{name: mdnsServiceEnum, rtype: dnsTypePTR, ttl: ttl, data: encodeDNSName(MDNSServiceType)})
+		}
+	case strings.EqualFold(name, MDNSServiceType):
+		if matches(dnsTypePTR) {
+			records = append(records, dnsRecord{name: MDNSServiceType, rtype: dnsTypePTR, ttl: ttl, data: encodeDNSName(s.instanceName())})
+		}
+	case strings.EqualFold(name, s.instanceName()):
+		if matches(dnsTypeSRV) {
+			srv := binary.BigEndian.AppendUint16(nil, 0) // priority
+			srv = binary.BigEndian.AppendUint16(srv, 0)  // weight
+			srv = binary.BigEndian.AppendUint16(srv, uint16(s.Port))
+			srv = append(srv, encodeDNSName(s.hostName())...)
+			records = append(records, dnsRecord{name: s.instanceName(), rtype: dnsTypeSRV, flush: true, ttl: ttl, data: srv})
+		}
+		if matches(dnsTypeTXT) {
+			var txt []byte
+			for _, entry := range s.TXT {
+				if len(entry) > 255 {
+					entry = entry[:255]
+				}
+				txt = append(txt, byte(len(entry)))
+				txt = append(txt, entry...)
+			}
+			records = append(records, dnsRecord{name: s.instanceName(), rtype: dnsTypeTXT, flush: true, ttl: ttl, data: txt})
+		}
+	case strings.EqualFold(name, s.hostName()):
+		for _, ip := range s.IPs {
+			if ip4 := ip.To4(); ip4 != nil && matches(dnsTypeA) {
+				records = append(records, dnsRecord{name: s.hostName(), rtype: dnsTypeA, flush: true, ttl: ttl, data: ip4})
+			} else if ip4 == nil && matches(dnsTypeAAAA) {
+				records = append(records, dnsRecord{name: s.hostName(), rtype: dnsTypeAAAA, flush: true, ttl: ttl, data: ip.To16()})
+			}
+		}
+	}
+	return records
+}
+
+// builds a response answering questions, or an announcement of everything when
+// questions is nil. the records a browser needs next (srv, txt, addresses) always
+// ride along in the additional section so it can connect without asking again
+func buildMDNSResponse(s MDNSService, id uint16, questions []dnsQuestion, ttl uint32) ([]byte, error) {
+	var answers []dnsRecord
+	if questions == nil {
+		answers = append(answers, serviceRecords(s, mdnsServiceEnum, dnsTypePTR, ttl)...)
+		answers = append(answers, serviceRecords(s, MDNSServiceType, dnsTypePTR, ttl)...)
+	}
+	for _, q := range questions {
+		answers = append(answers, serviceRecords(s, q.name, q.qtype, ttl)...)
+	}
+
+	var additional []dnsRecord
+	for _, rec := range append(serviceRecords(s, s.instanceName(), dnsTypeANY, ttl), serviceRecords(s, s.hostName(), dnsTypeANY, ttl)...) {
+		duplicate := false
+		for _, answer := range answers {
+			if answer.rtype == rec.rtype && strings.EqualFold(answer.name, rec.name) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			additional = append(additional, rec)
+		}
+	}
+
+	// a legacy unicast reply has to repeat the questions, multicast ones must not
+	echoQuestions := id != 0
+	msg := binary.BigEndian.AppendUint16(nil, id)
+	msg = binary.BigEndian.AppendUint16(msg, 0x8400) // response, authoritative
+	if echoQuestions {
+		msg = binary.BigEndian.AppendUint16(msg, uint16(len(questions)))
+	} else {
+		msg = binary.BigEndian.AppendUint16(msg, 0)
+	}
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(answers)))
+	msg = binary.BigEndian.AppendUint16(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(additional)))
+
+	if echoQuestions {
+		for _, q := range questions {
+			msg = append(msg, encodeDNSName(q.name)...)
+			msg = binary.BigEndian.AppendUint16(msg, q.qtype)
+			msg = binary.BigEndian.AppendUint16(msg, dnsClassIN)
+		}
+	}
+	for _, rec := range append(answers, additional...) {
+		class := uint16(dnsClassIN)
+		if rec.flush && !echoQuestions {
+			class |= dnsClassCacheFlush
+		}
+		msg = append(msg, encodeDNSName(rec.name)...)
+		msg = binary.BigEndian.AppendUint16(msg, rec.rtype)
+		msg = binary.BigEndian.AppendUint16(msg, class)
+		msg = binary.BigEndian.AppendUint32(msg, rec.ttl)
+		if len(rec.data) > 0xffff {
+			return nil, fmt.Errorf("record %s too large", rec.name)
+		}
+		msg = binary.BigEndian.AppendUint16(msg, uint16(len(rec.data)))
+		msg = append(msg, rec.data...)
+	}
+	return msg, nil
+}
+
+func parseDNSQuestions(msg []byte, count int) ([]dnsQuestion, error) {
+	offset := 12
+	questions := make([]dnsQuestion, 0, count)
+	for range count {
+		name, next, err := readDNSName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(msg) {
+			return nil, fmt.Errorf("truncated question")
+		}
+		questions = append(questions, dnsQuestion{
+			name:   name,
+			qtype:  binary.BigEndian.Uint16(msg[next:]),
+			qclass: binary.BigEndian.Uint16(msg[next+2:]),
+		})
+		offset = next + 4
+	}
+	return questions, nil
+}
+
+// reads a possibly compressed name at offset, returns it and the offset after it
+func readDNSName(msg []byte, offset int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; ; {
+		if offset >= len(msg) {
+			return "", 0, fmt.Errorf("name out of bounds")
+		}
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case length&0xc0 == 0xc0:
+			if offset+1 >= len(msg) {
+				return "", 0, fmt.Errorf("truncated compression pointer")
+			}
+			if jumps++; jumps > 16 {
+				return "", 0, fmt.Errorf("compression loop")
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:]) & 0x3fff)
+		default:
+			if offset+1+length > len(msg) {
+				return "", 0, fmt.Errorf("label out of bounds")
+			}
+			labels = append(labels, escapeDNSLabel(string(msg[offset+1:offset+1+length])))
+			offset += 1 + length
+		}
+	}
+}
+
+// encodes a dotted name, dots inside a label are escaped as \.
+func encodeDNSName(name string) []byte {
+	var buf []byte
+	var label []byte
+	flush := func() {
+		if len(label) > 63 {
+			label = label[:63]
+		}
+		buf = append(buf, byte(len(label)))
+		buf = append(buf, label...)
+		label = label[:0]
+	}
+	for i := 0; i < len(name); i++ {
+		switch {
+		case name[i] == '\\' && i+1 < len(name):
+			i++
+			label = append(label, name[i])
+		case name[i] == '.':
+			flush()
+		default:
+			label = append(label, name[i])
+		}
+	}
+	if len(label) > 0 {
+		flush()
+	}
+	return append(buf, 0)
+}
+
+func escapeDNSLabel(label string) string {
+	label = strings.ReplaceAll(label, `\`, `\\`)
+	return strings.ReplaceAll(label, ".", `\.`)
+}
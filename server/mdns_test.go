@@ -0,0 +1,248 @@
+package server
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func testMDNSResponder() *MDNSResponder {
+	return NewMDNSResponder(nil, func() MDNSService {
+		return MDNSService{
+			Instance: "Quick Mouse on desk",
+			Host:     "desk",
+			Port:     3000,
+			TXT:      []string{"port=3000", "pv=2", "fp=abcd"},
+			IPs:      []net.IP{net.IPv4(192, 168, 1, 20)},
+		}
+	})
+}
+
+// builds a query packet with one question per name/type pair
+func dnsQuery(id uint16, class uint16, questions ...dnsQuestion) []byte {
+	msg := binary.BigEndian.AppendUint16(nil, id)
+	msg = binary.BigEndian.AppendUint16(msg, 0) // standard query
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(questions)))
+	msg = append(msg, 0, 0, 0, 0, 0, 0)
+	for _, q := range questions {
+		msg = append(msg, encodeDNSName(q.name)...)
+		msg = binary.BigEndian.AppendUint16(msg, q.qtype)
+		msg = binary.BigEndian.AppendUint16(msg, class)
+	}
+	return msg
+}
+
+type parsedDNSResponse struct {
+	id         uint16
+	questions  []dnsQuestion
+	answers    []dnsRecord
+	additional []dnsRecord
+}
+
+func parseDNSResponse(t *testing.T, msg []byte) parsedDNSResponse {
+	t.Helper()
+	if len(msg) < 12 {
+		t.Fatalf("response too short: %d bytes", len(msg))
+	}
+	if flags := binary.BigEndian.Uint16(msg[2:]); flags&0x8000 == 0 {
+		t.Fatalf("response flag not set: %#04x", flags)
+	}
+	response := parsedDNSResponse{id: binary.BigEndian.Uint16(msg)}
+	questions, err := parseDNSQuestions(msg, int(binary.BigEndian.Uint16(msg[4:])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.questions = questions
+
+	offset := 12
+	for range questions {
+		_, next, _ := readDNSName(msg, offset)
+		offset = next + 4
+	}
+	readRecords := func(count int) []dnsRecord {
+		var records []dnsRecord
+		for range count {
+			name, next, err := readDNSName(msg, offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			length := int(binary.BigEndian.Uint16(msg[next+8:]))
+			records = append(records, dnsRecord{
+				name:  name,
+				rtype: binary.BigEndian.Uint16(msg[next:]),
+				flush: binary.BigEndian.Uint16(msg[next+2:])&dnsClassCacheFlush != 0,
+				ttl:   binary.BigEndian.Uint32(msg[next+4:]),
+				data:  msg[next+10 : next+10+length],
+			})
+			offset = next + 10 + length
+		}
+		return records
+	}
+	response.answers = readRecords(int(binary.BigEndian.Uint16(msg[6:])))
+	response.additional = readRecords(int(binary.BigEndian.Uint16(msg[10:])))
+	if offset != len(msg) {
+		t.Fatalf("%d bytes left after the records", len(msg)-offset)
+	}
+	return response
+}
+
+func decodeName(t *testing.T, data []byte) string {
+	t.Helper()
+	name, _, err := readDNSName(data, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestMDNSAnswers(t *testing.T) {
+	instance := `Quick Mouse on desk._quickmouse._tcp.local.`
+
+	tests := []struct {
+		name  string
+		qname string
+		qtype uint16
+		check func(t *testing.T, answer dnsRecord)
+	}{
+		{"service enumeration", "_services._dns-sd._udp.local.", dnsTypePTR, func(t *testing.T, answer dnsRecord) {
+			if got := decodeName(t, answer.data); got != MDNSServiceType {
+				t.Fatalf("PTR points at %q", got)
+			}
+		}},
+		{"service browse", "_quickmouse._tcp.local.", dnsTypePTR, func(t *testing.T, answer dnsRecord) {
+			if got := decodeName(t, answer.data); got != instance {
+				t.Fatalf("PTR points at %q", got)
+			}
+			if answer.flush {
+				t.Fatal("shared PTR record has the cache flush bit")
+			}
+		}},
+		{"service browse any case", "_QuickMouse._TCP.local.", dnsTypePTR, nil},
+		{"srv", instance, dnsTypeSRV, func(t *testing.T, answer dnsRecord) {
+			if port := binary.BigEndian.Uint16(answer.data[4:]); port != 3000 {
+				t.Fatalf("SRV port %d", port)
+			}
+			if target := decodeName(t, answer.data[6:]); target != "desk.local." {
+				t.Fatalf("SRV target %q", target)
+			}
+		}},
+		{"txt", instance, dnsTypeTXT, func(t *testing.T, answer dnsRecord) {
+			var entries []string
+			for data := answer.data; len(data) > 0; data = data[1+int(data[0]):] {
+				entries = append(entries, string(data[1:1+int(data[0])]))
+			}
+			if want := []string{"port=3000", "pv=2", "fp=abcd"}; !reflect.DeepEqual(entries, want) {
+				t.Fatalf("TXT %q, want %q", entries, want)
+			}
+		}},
+		{"a", "desk.local.", dnsTypeA, func(t *testing.T, answer dnsRecord) {
+			if ip := net.IP(answer.data); !ip.Equal(net.IPv4(192, 168, 1, 20)) {
+				t.Fatalf("A record %s", ip)
+			}
+			if !answer.flush {
+				t.Fatal("unique A record is missing the cache flush bit")
+			}
+		}},
+	}
+
+	r := testMDNSResponder()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, unicast, ok := r.HandleQuery(dnsQuery(0, dnsClassIN, dnsQuestion{name: tt.qname, qtype: tt.qtype}), false)
+			if !ok {
+				t.Fatal("no response")
+			}
+			if unicast {
+				t.Fatal("multicast query answered unicast")
+			}
+			response := parseDNSResponse(t, data)
+			if response.id != 0 || len(response.questions) != 0 {
+				t.Fatalf("multicast response has id %d and %d questions", response.id, len(response.questions))
+			}
+			if len(response.answers) != 1 {
+				t.Fatalf("got %d answers, want 1", len(response.answers))
+			}
+			if answer := response.answers[0]; answer.rtype != tt.qtype || answer.ttl != mdnsTTL {
+				t.Fatalf("answer type %d ttl %d", answer.rtype, answer.ttl)
+			}
+			if tt.check != nil {
+				tt.check(t, response.answers[0])
+			}
+		})
+	}
+}
+
+func TestMDNSBrowseCarriesAdditionalRecords(t *testing.T) {
+	data, _, ok := testMDNSResponder().HandleQuery(dnsQuery(0, dnsClassIN, dnsQuestion{name: MDNSServiceType, qtype: dnsTypePTR}), false)
+	if !ok {
+		t.Fatal("no response")
+	}
+	var types []uint16
+	for _, record := range parseDNSResponse(t, data).additional {
+		types = append(types, record.rtype)
+	}
+	if want := []uint16{dnsTypeSRV, dnsTypeTXT, dnsTypeA}; !reflect.DeepEqual(types, want) {
+		t.Fatalf("additional record types %v, want %v", types, want)
+	}
+}
+
+func TestMDNSIgnoresOtherNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		query []byte
+	}{
+		{"other service", dnsQuery(0, dnsClassIN, dnsQuestion{name: "_printer._tcp.local.", qtype: dnsTypePTR})},
+		{"other host", dnsQuery(0, dnsClassIN, dnsQuestion{name: "laptop.local.", qtype: dnsTypeA})},
+		{"our host wrong type", dnsQuery(0, dnsClassIN, dnsQuestion{name: "desk.local.", qtype: dnsTypeAAAA})},
+		{"truncated", dnsQuery(0, dnsClassIN, dnsQuestion{name: MDNSServiceType, qtype: dnsTypePTR})[:20]},
+		{"too short", []byte{0, 0, 0}},
+	}
+
+	r := testMDNSResponder()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if data, _, ok := r.HandleQuery(tt.query, false); ok {
+				t.Fatalf("answered with %d bytes", len(data))
+			}
+		})
+	}
+}
+
+func TestMDNSIgnoresResponses(t *testing.T) {
+	query := dnsQuery(0, dnsClassIN, dnsQuestion{name: MDNSServiceType, qtype: dnsTypePTR})
+	binary.BigEndian.PutUint16(query[2:], 0x8400)
+	if _, _, ok := testMDNSResponder().HandleQuery(query, false); ok {
+		t.Fatal("answered another responder's response")
+	}
+}
+
+func TestMDNSUnicastReplies(t *testing.T) {
+	r := testMDNSResponder()
+	question := dnsQuestion{name: "desk.local.", qtype: dnsTypeA}
+
+	// the QU bit asks for a unicast reply but it is still an mdns response
+	data, unicast, ok := r.HandleQuery(dnsQuery(0, dnsClassIN|dnsClassUnicast, question), false)
+	if !ok || !unicast {
+		t.Fatalf("QU question: ok=%v unicast=%v", ok, unicast)
+	}
+	if response := parseDNSResponse(t, data); response.id != 0 || len(response.questions) != 0 {
+		t.Fatal("QU response echoed the query")
+	}
+
+	// legacy resolvers get their id and question back
+	data, unicast, ok = r.HandleQuery(dnsQuery(0x1234, dnsClassIN, question), true)
+	if !ok || !unicast {
+		t.Fatalf("legacy query: ok=%v unicast=%v", ok, unicast)
+	}
+	response := parseDNSResponse(t, data)
+	if response.id != 0x1234 {
+		t.Fatalf("legacy response id %#x", response.id)
+	}
+	if want := []dnsQuestion{{name: "desk.local.", qtype: dnsTypeA, qclass: dnsClassIN}}; !reflect.DeepEqual(response.questions, want) {
+		t.Fatalf("questions %v, want %v", response.questions, want)
+	}
+	if response.answers[0].flush {
+		t.Fatal("legacy unicast answer has the cache flush bit")
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+// device_motion and calibration packets carry the phone's Date.now() in ms. over
+// wi-fi they arrive bunched up, late or now and then out of order, so the samples
+// are lined up by that timestamp instead of by arrival: anything older than a
+// sample already seen is dropped, samples held up far longer than the network
+// usually takes are dropped as stale, and each sample pushes the cursor for the
+// time it actually covered instead of one fixed step.
+//
+// the phone and server clocks are not synced, so lateness is measured against the
+// quickest transit seen lately (arrival time minus timestamp), which takes the
+// clock offset out of the picture. jitter is the smoothed difference between how
+// far apart two samples were sent and how far apart they arrived, as in rtp.
+
+import (
+	"math"
+	"time"
+)
+
+// the motion forces were tuned with one push per 60hz sample
+const motionNominalInterval = 1000.0 / 60 // ms
+
+// longest time one sample is allowed to cover, a gap past this was the phone
+// pausing and not a slow sensor
+const motionMaxInterval = 100.0 // ms
+
+// a sample this much later than the quickest transit is stale, on top of
+// motionStaleJitter times the current jitter
+const motionStaleDelay = 100.0 // ms
+const motionStaleJitter = 3.0
+
+// a pause this long, or the phone clock jumping back this far, starts over
+const motionResync = time.Second
+
+// how quickly the quickest transit creeps up when the network gets slower for good
+const motionTransitDrift = 0.01
+
+type motionState struct {
+	lastTimestamp int64 // newest sample seen, applied or not
+	lastArrival   time.Time
+	lastApplied   int64   // newest sample that moved the cursor
+	baseTransit   float64 // quickest arrival minus timestamp lately, ms
+	jitter        float64 // ms
+	dropped       int
+}
+
+// decides whether a sample sent at timestamp and received at now should be used
+// and how many ms of motion it stands for
+func (m *motionState) accept(timestamp int64, now time.Time) (float64, bool) {
+	if timestamp <= 0 {
+		// the debug page and older clients send no timestamp, take them as they come
+		return motionNominalInterval, true
+	}
+	transit := float64(now.UnixNano())/float64(time.Millisecond) - float64(timestamp)
+
+	if m.lastTimestamp == 0 || now.Sub(m.lastArrival) > motionResync || m.lastTimestamp-timestamp > motionResync.Milliseconds() {
+		*m = motionState{
+			lastTimestamp: timestamp,
+			lastArrival:   now,
+			lastApplied:   timestamp,
+			baseTransit:   transit,
+			dropped:       m.dropped,
+		}
+		return motionNominalInterval, true
+	}
+
+	if timestamp <= m.lastTimestamp {
+		m.dropped++
+		return 0, false
+	}
+
+	sent := float64(timestamp - m.lastTimestamp)
+	arrived := float64(now.Sub(m.lastArrival)) / float64(time.Millisecond)
+	m.jitter += (math.Abs(arrived-sent) - m.jitter) / 16
+	m.lastTimestamp = timestamp
+	m.lastArrival = now
+
+	if transit < m.baseTransit {
+		m.baseTransit = transit
+	} else {
+		m.baseTransit += (transit - m.baseTransit) * motionTransitDrift
+	}
+	if transit-m.baseTransit > motionStaleDelay+motionStaleJitter*m.jitter {
+		m.dropped++
+		return 0, false
+	}
+
+	// a dropped sample's time is covered by the next one that gets through
+	interval := math.Min(float64(timestamp-m.lastApplied), motionMaxInterval)
+	m.lastApplied = timestamp
+	return interval, true
+}
+
+// estimated network jitter on motion packets and how many were dropped as stale
+// or out of order
+func (c *PacketController) MotionStats() (time.Duration, int) {
+	c.physicsMu.RLock()
+	defer c.physicsMu.RUnlock()
+	return time.Duration(c.motion.jitter * float64(time.Millisecond)), c.motion.dropped
+}
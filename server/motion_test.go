@@ -0,0 +1,143 @@
+package server
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMotionAccept(t *testing.T) {
+	type sample struct {
+		arrive       time.Duration // after the previous sample
+		sent         int64         // ms on the phone clock, from the first sample
+		wantOK       bool
+		wantInterval float64 // ms, checked when the sample is used
+	}
+
+	tests := []struct {
+		name        string
+		samples     []sample
+		wantDropped int
+	}{
+		{
+			name: "in order",
+			samples: []sample{
+				{0, 0, true, motionNominalInterval},
+				{16 * time.Millisecond, 16, true, 16},
+				{18 * time.Millisecond, 33, true, 17},
+				{15 * time.Millisecond, 50, true, 17},
+			},
+		},
+		{
+			name: "out of order",
+			samples: []sample{
+				{0, 0, true, motionNominalInterval},
+				{16 * time.Millisecond, 32, true, 32},
+				{1 * time.Millisecond, 16, false, 0}, // overtaken by the one above
+				{15 * time.Millisecond, 48, true, 16},
+				{1 * time.Millisecond, 48, false, 0}, // duplicate
+			},
+			wantDropped: 2,
+		},
+		{
+			name: "stale",
+			samples: []sample{
+				{0, 0, true, motionNominalInterval},
+				{16 * time.Millisecond, 16, true, 16},
+				{16 * time.Millisecond, 32, true, 16},
+				// held up 40ms, late but within what the network usually takes
+				{56 * time.Millisecond, 48, true, 16},
+				// held up 150ms, over motionStaleDelay plus three times the jitter
+				{126 * time.Millisecond, 64, false, 0},
+				// the next one on time again covers the time of the dropped one
+				{1 * time.Millisecond, 100, true, 52},
+			},
+			wantDropped: 1,
+		},
+		{
+			name: "long gap resets the baseline",
+			samples: []sample{
+				{0, 0, true, motionNominalInterval},
+				{16 * time.Millisecond, 16, true, 16},
+				// the phone paused for two seconds and the network got half a second
+				// slower meanwhile. that is not stale, it is a fresh start
+				{2500 * time.Millisecond, 2016, true, motionNominalInterval},
+				{16 * time.Millisecond, 2032, true, 16},
+				{16 * time.Millisecond, 2048, true, 16},
+			},
+		},
+		{
+			name: "phone clock jumping back resets the baseline",
+			samples: []sample{
+				{0, 5000, true, motionNominalInterval},
+				{16 * time.Millisecond, 5016, true, 16},
+				{16 * time.Millisecond, 0, true, motionNominalInterval},
+				{16 * time.Millisecond, 16, true, 16},
+			},
+		},
+	}
+
+	// the phone clock is nowhere near the server's, only differences count
+	const phoneEpoch = 1_600_000_000_000
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewFakeClock(time.Unix(1700000000, 0))
+			var m motionState
+			for i, s := range tt.samples {
+				clock.Advance(s.arrive)
+				interval, ok := m.accept(phoneEpoch+s.sent, clock.Now())
+				if ok != s.wantOK {
+					t.Fatalf("sample %d (sent at %dms): ok=%v, want %v", i, s.sent, ok, s.wantOK)
+				}
+				if ok && math.Abs(interval-s.wantInterval) > 1e-9 {
+					t.Fatalf("sample %d (sent at %dms): covers %vms, want %vms", i, s.sent, interval, s.wantInterval)
+				}
+			}
+			if m.dropped != tt.wantDropped {
+				t.Fatalf("dropped %d, want %d", m.dropped, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestMotionWithoutTimestamp(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1700000000, 0))
+	var m motionState
+	for range 3 {
+		// older clients and the debug page repeat or leave out the timestamp
+		if interval, ok := m.accept(0, clock.Now()); !ok || interval != motionNominalInterval {
+			t.Fatalf("untimed sample: ok=%v interval=%v", ok, interval)
+		}
+	}
+}
+
+func TestStaleMotionDoesNotPush(t *testing.T) {
+	c, _, _, clock := newTestController(t)
+	send := func(sent int64) {
+		if err := c.ProcessPacket(&DeviceMotionPacket{RotGamma: 10, Timestamp: 1_600_000_000_000 + sent, HandheldSensitivity: 5}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	velocity := func() float64 {
+		c.physicsMu.Lock()
+		defer c.physicsMu.Unlock()
+		return c.velocityX
+	}
+
+	send(0)
+	clock.Advance(16 * time.Millisecond)
+	send(16)
+	before := velocity()
+
+	clock.Advance(time.Millisecond)
+	send(8) // out of order
+	clock.Advance(200 * time.Millisecond)
+	send(32) // stale
+	if after := velocity(); after != before {
+		t.Fatalf("dropped samples moved the velocity from %v to %v", before, after)
+	}
+	if _, dropped := c.MotionStats(); dropped != 2 {
+		t.Fatalf("MotionStats reports %d dropped, want 2", dropped)
+	}
+}
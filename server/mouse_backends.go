@@ -59,15 +59,21 @@ func DetectDisplayServer() DisplayType {
 type MouseController interface {
 	MoveRelative(dx, dy int32) error
 	MoveTo(x, y int) error
-	Click(button string) error
-	Press(button string) error
-	Release(button string) error
+	Click(button Button) error
+	Press(button Button) error
+	Release(button Button) error
 	GetPosition() (int, int, error)
 	Scroll(deltaX, deltaY int32) error
 	CenterOnMainDisplay() error
 	Close() error
 }
 
+// implemented by backends that can scroll by fractions of a notch
+type HiResScroller interface {
+	// deltas are in 1/120ths of a notch
+	ScrollHiRes(deltaX, deltaY int32) error
+}
+
 // creates a mouse controller for the detected platform
 func NewMouseController() (MouseController, error) {
 	displayType := DetectDisplayServer()
@@ -104,6 +110,18 @@ func NewUniversalMouse() (*UniversalMouse, error) {
 	}, nil
 }
 
+// name of the backend actually driving the mouse, reported to clients in auth_ok
+func (m *UniversalMouse) Backend() string {
+	switch m.displayType {
+	case Wayland:
+		return "uinput"
+	case X11, Windows, MacOS:
+		return "robotgo"
+	default:
+		return "custom"
+	}
+}
+
 func (m *UniversalMouse) MoveRelative(dx, dy int32) error {
 	return m.controller.MoveRelative(dx, dy)
 }
@@ -114,15 +132,15 @@ func (m *UniversalMouse) MoveTo(x, y int) error {
 }
 
 // NOTE: Not used in main server controller, available for testing/other purposes
-func (m *UniversalMouse) Click(button string) error {
+func (m *UniversalMouse) Click(button Button) error {
 	return m.controller.Click(button)
 }
 
-func (m *UniversalMouse) Press(button string) error {
+func (m *UniversalMouse) Press(button Button) error {
 	return m.controller.Press(button)
 }
 
-func (m *UniversalMouse) Release(button string) error {
+func (m *UniversalMouse) Release(button Button) error {
 	return m.controller.Release(button)
 }
 
@@ -135,13 +153,26 @@ func (m *UniversalMouse) Scroll(deltaX, deltaY int32) error {
 	return m.controller.Scroll(deltaX, deltaY)
 }
 
+func (m *UniversalMouse) SupportsHiResScroll() bool {
+	_, ok := m.controller.(HiResScroller)
+	return ok
+}
+
+// scrolls in 1/120ths of a notch, backends without hi-res scrolling only get whole notches
+func (m *UniversalMouse) ScrollHiRes(deltaX, deltaY int32) error {
+	if scroller, ok := m.controller.(HiResScroller); ok {
+		return scroller.ScrollHiRes(deltaX, deltaY)
+	}
+	return m.controller.Scroll(deltaX/hiResScrollUnits, deltaY/hiResScrollUnits)
+}
+
 func (m *UniversalMouse) CenterOnMainDisplay() error {
 	return m.controller.CenterOnMainDisplay()
 }
 
 // NOTE: Not used in main server controller, available for testing/other purposes
 func (m *UniversalMouse) Drag(dx, dy int32, duration time.Duration) error {
-	if err := m.Press("left"); err != nil {
+	if err := m.Press(ButtonLeft); err != nil {
 		return err
 	}
 	time.Sleep(100 * time.Millisecond)
@@ -162,7 +193,7 @@ func (m *UniversalMouse) Drag(dx, dy int32, duration time.Duration) error {
 		time.Sleep(duration / time.Duration(steps))
 	}
 
-	return m.Release("left")
+	return m.Release(ButtonLeft)
 }
 
 func (m *UniversalMouse) Close() error {
@@ -186,42 +217,63 @@ func (m *RobotgoMouse) MoveTo(x, y int) error {
 	return nil
 }
 
-func (m *RobotgoMouse) Click(button string) error {
+// robotgo cannot press the side buttons, so back and forward are sent as the browser
+// shortcuts for them instead. that happens on press, release has nothing left to do
+func robotgoSideButton(button Button) error {
+	if runtime.GOOS == "darwin" {
+		if button == ButtonBack {
+			return robotgo.KeyTap("[", "cmd")
+		}
+		return robotgo.KeyTap("]", "cmd")
+	}
+	if button == ButtonBack {
+		return robotgo.KeyTap("left", "alt")
+	}
+	return robotgo.KeyTap("right", "alt")
+}
+
+func (m *RobotgoMouse) Click(button Button) error {
 	switch button {
-	case "left":
+	case ButtonLeft:
 		robotgo.Click("left")
-	case "right":
+	case ButtonRight:
 		robotgo.Click("right")
-	case "middle":
+	case ButtonMiddle:
 		robotgo.Click("center")
+	case ButtonBack, ButtonForward:
+		return robotgoSideButton(button)
 	default:
 		return fmt.Errorf("Unknown robotgo click action %v", button)
 	}
 	return nil
 }
 
-func (m *RobotgoMouse) Press(button string) error {
+func (m *RobotgoMouse) Press(button Button) error {
 	switch button {
-	case "left":
+	case ButtonLeft:
 		robotgo.MouseDown("left")
-	case "right":
+	case ButtonRight:
 		robotgo.MouseDown("right")
-	case "middle":
+	case ButtonMiddle:
 		robotgo.MouseDown("center")
+	case ButtonBack, ButtonForward:
+		return robotgoSideButton(button)
 	default:
 		return fmt.Errorf("Unknown robotgo click action %v", button)
 	}
 	return nil
 }
 
-func (m *RobotgoMouse) Release(button string) error {
+func (m *RobotgoMouse) Release(button Button) error {
 	switch button {
-	case "left":
+	case ButtonLeft:
 		robotgo.MouseUp("left")
-	case "right":
+	case ButtonRight:
 		robotgo.MouseUp("right")
-	case "middle":
+	case ButtonMiddle:
 		robotgo.MouseUp("center")
+	case ButtonBack, ButtonForward:
+		return nil
 	default:
 		return fmt.Errorf("Unknown robotgo click action %v", button)
 	}
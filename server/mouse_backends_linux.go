@@ -10,11 +10,20 @@ import (
 )
 
 type WaylandMouse struct {
-	device uinput.Mouse
+	device *uinputPointer
+}
+
+// kernel button codes for the button names used over the protocol
+var uinputButtons = map[Button]uint16{
+	ButtonLeft:    btnLeft,
+	ButtonRight:   btnRight,
+	ButtonMiddle:  btnMiddle,
+	ButtonBack:    btnSide,
+	ButtonForward: btnExtra,
 }
 
 func newWaylandMouse() (MouseController, error) {
-	mouse, err := uinput.CreateMouse("/dev/uinput", []byte("virtual-mouse"))
+	mouse, err := newUinputPointer("/dev/uinput", "virtual-mouse")
 	if err != nil {
 		return nil, fmt.Errorf("failed to create uinput device: %v\n"+
 			"Make sure you have permissions. Run:\n"+
@@ -34,43 +43,27 @@ func (m *WaylandMouse) MoveTo(x, y int) error {
 	return fmt.Errorf("absolute positioning not supported with uinput backend")
 }
 
-func (m *WaylandMouse) Click(button string) error {
-	switch button {
-	case "left":
-		return m.device.LeftClick()
-	case "right":
-		return m.device.RightClick()
-	case "middle":
-		return m.device.MiddleClick()
-	default:
-		return fmt.Errorf("Unknown wayland click action %v", button)
+func (m *WaylandMouse) Click(button Button) error {
+	if err := m.Press(button); err != nil {
+		return err
 	}
+	return m.Release(button)
 }
 
-func (m *WaylandMouse) Press(button string) error {
-	switch button {
-	case "left":
-		return m.device.LeftPress()
-	case "right":
-		return m.device.RightPress()
-	case "middle":
-		return m.device.MiddlePress()
-	default:
+func (m *WaylandMouse) Press(button Button) error {
+	code, exists := uinputButtons[button]
+	if !exists {
 		return fmt.Errorf("Unknown wayland click action %v", button)
 	}
+	return m.device.Button(code, true)
 }
 
-func (m *WaylandMouse) Release(button string) error {
-	switch button {
-	case "left":
-		return m.device.LeftRelease()
-	case "right":
-		return m.device.RightRelease()
-	case "middle":
-		return m.device.MiddleRelease()
-	default:
+func (m *WaylandMouse) Release(button Button) error {
+	code, exists := uinputButtons[button]
+	if !exists {
 		return fmt.Errorf("Unknown wayland click action %v", button)
 	}
+	return m.device.Button(code, false)
 }
 
 func (m *WaylandMouse) GetPosition() (int, int, error) {
@@ -78,18 +71,11 @@ func (m *WaylandMouse) GetPosition() (int, int, error) {
 }
 
 func (m *WaylandMouse) Scroll(deltaX, deltaY int32) error {
-	// uinput Wheel takes (isVertical bool, delta int32)
-	if deltaY != 0 {
-		if err := m.device.Wheel(false, deltaY); err != nil {
-			return err
-		}
-	}
-	if deltaX != 0 {
-		if err := m.device.Wheel(true, deltaX); err != nil {
-			return err
-		}
-	}
-	return nil
+	return m.device.Wheel(deltaX, deltaY)
+}
+
+func (m *WaylandMouse) ScrollHiRes(deltaX, deltaY int32) error {
+	return m.device.WheelHiRes(deltaX, deltaY)
 }
 
 func (m *WaylandMouse) CenterOnMainDisplay() error {
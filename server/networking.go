@@ -17,6 +17,9 @@ package server
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
+	"slices"
+	"strings"
 )
 
 // PacketType represents the type of network packet
@@ -39,6 +42,16 @@ const (
 	CalibrationDone PacketType = "calibration_done"
 	ConfigSync      PacketType = "config_sync"
 	ConfigUpdate    PacketType = "config_update"
+	AuthOk          PacketType = "auth_ok"
+	AuthResult      PacketType = "auth_result"
+	KeyDown         PacketType = "key_down"
+	KeyUp           PacketType = "key_up"
+	KeyTap          PacketType = "key_tap"
+	TextInput       PacketType = "text_input"
+	ButtonDown      PacketType = "button_down"
+	ButtonUp        PacketType = "button_up"
+	ButtonEvent     PacketType = "button"
+	ScrollEnd       PacketType = "scroll_end"
 )
 
 // Packet registry for type reconstruction
@@ -56,6 +69,85 @@ var packetRegistry = map[PacketType]func() Packet{
 	CalibrationDone: func() Packet { return &CalibrationDonePacket{} },
 	ConfigSync:      func() Packet { return &ConfigSyncPacket{} },
 	ConfigUpdate:    func() Packet { return &ConfigUpdatePacket{} },
+	AuthOk:          func() Packet { return &AuthOkPacket{} },
+	AuthResult:      func() Packet { return &AuthResultPacket{} },
+	KeyDown:         func() Packet { return &KeyDownPacket{} },
+	KeyUp:           func() Packet { return &KeyUpPacket{} },
+	KeyTap:          func() Packet { return &KeyTapPacket{} },
+	TextInput:       func() Packet { return &TextInputPacket{} },
+	ButtonDown:      func() Packet { return &ButtonDownPacket{} },
+	ButtonUp:        func() Packet { return &ButtonUpPacket{} },
+	ButtonEvent:     func() Packet { return &ButtonPacket{} },
+	ScrollEnd:       func() Packet { return &ScrollEndPacket{} },
+}
+
+// one-byte tags used by the binary serializer to identify the packet type
+// NOTE: these go over the wire, so never renumber an existing entry, only append
+var binaryPacketTags = map[PacketType]byte{
+	Auth:            0x01,
+	MouseMove:       0x02,
+	DeviceMotion:    0x03,
+	LeftClickUp:     0x04,
+	RightClickUp:    0x05,
+	LeftClickDown:   0x06,
+	RightClickDown:  0x07,
+	ScrollMove:      0x08,
+	KeepAlive:       0x09,
+	Calibration:     0x0a,
+	CalibrationDone: 0x0b,
+	ConfigSync:      0x0c,
+	ConfigUpdate:    0x0d,
+	AuthOk:          0x0e,
+	AuthResult:      0x0f,
+	KeyDown:         0x10,
+	KeyUp:           0x11,
+	KeyTap:          0x12,
+	TextInput:       0x13,
+	ButtonDown:      0x14,
+	ButtonUp:        0x15,
+	ButtonEvent:     0x16,
+	ScrollEnd:       0x17,
+}
+
+// zero-valued json for every registered packet with its type filled in, sorted by
+// type. the debug ui builds its packet sender from this so it never falls behind
+func PacketTemplates() []PacketTemplate {
+	templates := make([]PacketTemplate, 0, len(packetRegistry))
+	for packetType, constructor := range packetRegistry {
+		packet := constructor()
+		fillEmptySlices(reflect.ValueOf(packet).Elem())
+		fields := map[string]any{}
+		data, err := json.Marshal(packet)
+		if err == nil {
+			json.Unmarshal(data, &fields)
+		}
+		fields["type"] = packetType
+		data, _ = json.MarshalIndent(fields, "", "  ")
+		templates = append(templates, PacketTemplate{Type: packetType, JSON: string(data)})
+	}
+	slices.SortFunc(templates, func(a, b PacketTemplate) int {
+		return strings.Compare(string(a.Type), string(b.Type))
+	})
+	return templates
+}
+
+// swaps nil slices for empty ones so templates show [] instead of null
+func fillEmptySlices(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.IsNil() {
+			v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			fillEmptySlices(v.Field(i))
+		}
+	}
+}
+
+type PacketTemplate struct {
+	Type PacketType `json:"type"`
+	JSON string     `json:"json"`
 }
 
 // represents a network packet that can be serialized
@@ -64,8 +156,16 @@ type Packet interface {
 }
 
 // data packet structs
+// protocolVersion and minProtocolVersion are missing from clients that predate the
+// handshake, those are treated as protocol version 1 (see NegotiateProtocol)
 type AuthPacket struct {
-	Key string `json:"key"`
+	Key                string   `json:"key"`
+	ProtocolVersion    int      `json:"protocolVersion"`
+	MinProtocolVersion int      `json:"minProtocolVersion"`
+	Serializers        []string `json:"serializers"`
+	Capabilities       []string `json:"capabilities"`
+	DeviceName         string   `json:"deviceName"`
+	DeviceToken        string   `json:"deviceToken"`
 }
 
 func (p AuthPacket) Type() PacketType {
@@ -129,6 +229,13 @@ func (p RightClickDownPacket) Type() PacketType {
 	return RightClickDown
 }
 
+// the fingers left the screen after a scroll, starts kinetic scrolling if it is on
+type ScrollEndPacket struct{}
+
+func (p ScrollEndPacket) Type() PacketType {
+	return ScrollEnd
+}
+
 type KeepAlivePacket struct{}
 
 func (p KeepAlivePacket) Type() PacketType {
@@ -153,15 +260,17 @@ func (p CalibrationDonePacket) Type() PacketType {
 }
 
 type ConfigSyncPacket struct {
-	PacketType           string  `json:"type"`
-	LastPort             int     `json:"lastPort"`
-	PointerSensitivity   float64 `json:"pointerSensitivity"`
-	HandheldSensitivity  float64 `json:"handheldSensitivity"`
-	ScrollSensitivity    float64 `json:"scrollSensitivity"`
-	ShowSensorLog        bool    `json:"showSensorLog"`
-	ButtonsAboveTouchpad bool    `json:"buttonsAboveTouchpad"`
-	NaturalScroll        bool    `json:"naturalScroll"`
-	SwapLeftRightClick   bool    `json:"swapLeftRightClick"`
+	PacketType           string             `json:"type"`
+	LastPort             int                `json:"lastPort"`
+	PointerSensitivity   float64            `json:"pointerSensitivity"`
+	HandheldSensitivity  float64            `json:"handheldSensitivity"`
+	ScrollSensitivity    float64            `json:"scrollSensitivity"`
+	ShowSensorLog        bool               `json:"showSensorLog"`
+	ButtonsAboveTouchpad bool               `json:"buttonsAboveTouchpad"`
+	NaturalScroll        bool               `json:"naturalScroll"`
+	SwapLeftRightClick   bool               `json:"swapLeftRightClick"`
+	Acceleration         AccelerationConfig `json:"acceleration"`
+	Physics              PhysicsParams      `json:"physics"`
 }
 
 func (p ConfigSyncPacket) Type() PacketType {
@@ -178,14 +287,114 @@ type ConfigUpdatePacket struct {
 	ButtonsAboveTouchpad bool    `json:"buttonsAboveTouchpad"`
 	NaturalScroll        bool    `json:"naturalScroll"`
 	SwapLeftRightClick   bool    `json:"swapLeftRightClick"`
+	// clients that do not know about acceleration or physics leave them empty, which
+	// keeps the server's current settings
+	Acceleration AccelerationConfig `json:"acceleration"`
+	Physics      PhysicsUpdate      `json:"physics"`
 }
 
 func (p ConfigUpdatePacket) Type() PacketType {
 	return ConfigUpdate
 }
 
+// sent by the server after a successful auth, tells the client what it can use
+type AuthOkPacket struct {
+	PacketType      string `json:"type"`
+	ProtocolVersion int    `json:"protocolVersion"`
+	// serializers the client may send with, the server itself always sends json
+	Serializers  []string `json:"serializers"`
+	Backend      string   `json:"backend"`
+	Buttons      []string `json:"buttons"`
+	Capabilities []string `json:"capabilities"`
+	SessionID    string   `json:"sessionId"`
+	// only set when the server just paired this device, the client should keep it
+	// and present it in auth on later connections instead of the qr key
+	DeviceToken string `json:"deviceToken,omitempty"`
+}
+
+func (p AuthOkPacket) Type() PacketType {
+	return AuthOk
+}
+
+// first reply to every auth packet, on anything but success the server closes the
+// connection right after sending it so the client knows why
+type AuthResultPacket struct {
+	PacketType         string `json:"type"`
+	Result             string `json:"result"`
+	Message            string `json:"message"`
+	ProtocolVersion    int    `json:"protocolVersion"`
+	MinProtocolVersion int    `json:"minProtocolVersion"`
+}
+
+func (p AuthResultPacket) Type() PacketType {
+	return AuthResult
+}
+
+// one action on any button, see buttons.go. preferred over everything below it
+type ButtonPacket struct {
+	Button Button       `json:"button"`
+	Action ButtonAction `json:"action"`
+}
+
+func (p ButtonPacket) Type() PacketType {
+	return ButtonEvent
+}
+
+// kept so older clients keep working, same as the left/right click packets above
+type ButtonDownPacket struct {
+	Button Button `json:"button"`
+}
+
+func (p ButtonDownPacket) Type() PacketType {
+	return ButtonDown
+}
+
+type ButtonUpPacket struct {
+	Button Button `json:"button"`
+}
+
+func (p ButtonUpPacket) Type() PacketType {
+	return ButtonUp
+}
+
+// keyboard packets, see keyboard_backends.go for the key names
+type KeyDownPacket struct {
+	Key string `json:"key"`
+}
+
+func (p KeyDownPacket) Type() PacketType {
+	return KeyDown
+}
+
+type KeyUpPacket struct {
+	Key string `json:"key"`
+}
+
+func (p KeyUpPacket) Type() PacketType {
+	return KeyUp
+}
+
+// presses and releases key with the modifiers held, e.g. ctrl+l
+type KeyTapPacket struct {
+	Key       string   `json:"key"`
+	Modifiers []string `json:"modifiers"`
+}
+
+func (p KeyTapPacket) Type() PacketType {
+	return KeyTap
+}
+
+// a whole string typed at once, unicode is up to the backend
+type TextInputPacket struct {
+	Text string `json:"text"`
+}
+
+func (p TextInputPacket) Type() PacketType {
+	return TextInput
+}
+
 // this interface will handle marshaling/unmarshaling packets
-// this is how we can switch between json and binary later
+// this is how we switch between json (text frames) and binary (binary frames)
 type Serializer interface {
 	Marshal(p Packet) ([]byte, error)
 	Unmarshal(data []byte, packetType PacketType) (Packet, error)
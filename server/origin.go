@@ -0,0 +1,79 @@
+package server
+
+// websocket upgrades are not covered by the browser's same-origin policy, so without
+// an origin check any page the user happens to open on the lan could try to talk to
+// the server. by default only pages served by the server itself (on any name it is
+// reachable by) are allowed, plus whatever custom client origins are allowlisted.
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type OriginPolicy struct {
+	ownHosts  func() []string // every host name/ip the server can be reached at
+	port      int
+	allowlist []string // normalized scheme://host:port origins
+}
+
+// ownHosts is asked on every check so ip changes are picked up
+func NewOriginPolicy(ownHosts func() []string, port int, allowlist []string) *OriginPolicy {
+	p := &OriginPolicy{ownHosts: ownHosts, port: port}
+	for _, origin := range allowlist {
+		if normalized, ok := normalizeOrigin(origin); ok {
+			p.allowlist = append(p.allowlist, normalized)
+		}
+	}
+	return p
+}
+
+// lower cased scheme://host:port with the default port filled in
+func normalizeOrigin(origin string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(origin))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", false
+	}
+	scheme := strings.ToLower(u.Scheme)
+	port := u.Port()
+	if port == "" {
+		switch scheme {
+		case "https":
+			port = "443"
+		case "http":
+			port = "80"
+		}
+	}
+	return scheme + "://" + net.JoinHostPort(strings.ToLower(u.Hostname()), port), true
+}
+
+// reports whether a websocket upgrade from origin should be accepted
+// an empty origin means a non-browser client, which a web page cannot impersonate
+func (p *OriginPolicy) Allowed(origin string) bool {
+	if origin == "" {
+		return true
+	}
+	normalized, ok := normalizeOrigin(origin)
+	if !ok {
+		return false
+	}
+	if slices.Contains(p.allowlist, normalized) {
+		return true
+	}
+
+	port := strconv.Itoa(p.port)
+	for _, host := range p.ownHosts() {
+		if normalized == "https://"+net.JoinHostPort(strings.ToLower(host), port) {
+			return true
+		}
+	}
+	return false
+}
+
+// for websocket.Upgrader.CheckOrigin
+func (p *OriginPolicy) Check(r *http.Request) bool {
+	return p.Allowed(r.Header.Get("Origin"))
+}
@@ -0,0 +1,85 @@
+package server
+
+// short numeric pairing codes for when the qr code cannot be scanned. the code is
+// shown in the tui and can be swapped once for the full session key. six digits are
+// easy to brute force, so every code expires quickly and is thrown away after a few
+// wrong guesses, which caps an attacker at maxAttempts tries per code.
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+var (
+	ErrPairingCodeInvalid     = errors.New("invalid pairing code")
+	ErrPairingCodeExpired     = errors.New("pairing code expired")
+	ErrPairingTooManyAttempts = errors.New("too many wrong pairing codes")
+)
+
+type PairingCodes struct {
+	mu          sync.Mutex
+	ttl         time.Duration
+	maxAttempts int
+
+	code     string
+	expires  time.Time
+	failures int
+}
+
+func NewPairingCodes(ttl time.Duration, maxAttempts int) *PairingCodes {
+	return &PairingCodes{ttl: ttl, maxAttempts: maxAttempts}
+}
+
+// must be called with mu held
+func (p *PairingCodes) rotate(now time.Time) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		// no code is better than a predictable one, redeem will reject everything
+		p.code = ""
+	} else {
+		p.code = fmt.Sprintf("%06d", n.Int64())
+	}
+	p.expires = now.Add(p.ttl)
+	p.failures = 0
+}
+
+// returns the code to show and when it expires, making a new one if needed
+func (p *PairingCodes) Current(now time.Time) (string, time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.code == "" || !now.Before(p.expires) {
+		p.rotate(now)
+	}
+	return p.code, p.expires
+}
+
+// checks a code typed on the client. a correct code is used up, so the next
+// device gets a fresh one
+func (p *PairingCodes) Redeem(code string, now time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.code == "" {
+		return ErrPairingCodeInvalid
+	}
+	if !now.Before(p.expires) {
+		p.rotate(now)
+		return ErrPairingCodeExpired
+	}
+	if subtle.ConstantTimeCompare([]byte(code), []byte(p.code)) != 1 {
+		p.failures++
+		if p.failures >= p.maxAttempts {
+			p.rotate(now)
+			return ErrPairingTooManyAttempts
+		}
+		return ErrPairingCodeInvalid
+	}
+
+	p.rotate(now)
+	return nil
+}
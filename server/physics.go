@@ -0,0 +1,167 @@
+package server
+
+// tuning for the handheld (air mouse) mode. tilting the phone pushes the cursor
+// velocity around, decay slows it down over time and the physics loop turns what
+// is left into cursor movement. all of it lives in the config so the feel can
+// be tuned per phone and per person without rebuilding.
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+type PhysicsParams struct {
+	Decay           float64 `json:"decay"`           // share of the velocity left after one second
+	MaxVelocity     float64 `json:"maxVelocity"`     // velocity is clamped to this on each axis
+	RotDeadzone     float64 `json:"rotDeadzone"`     // degrees of tilt the movement force ignores
+	CenteringForce  float64 `json:"centeringForce"`  // always on pull, per degree of tilt
+	MovementGain    float64 `json:"movementGain"`    // push per degree of tilt past the deadzone
+	PixelsPerSecond float64 `json:"pixelsPerSecond"` // cursor speed for each unit of velocity
+	TickRate        int     `json:"tickRate"`        // physics frames per second, one of PhysicsTickRates
+}
+
+// frame rates the physics loop can run at. the integration is time based so these
+// only change how smooth the cursor looks, not how far it goes
+var PhysicsTickRates = []int{60, 120, 240}
+
+// the feel the air mouse has always had. it used to keep 0.9 of its velocity and
+// move 15 pixels per unit of velocity on every 16ms frame, which works out to these
+var DefaultPhysicsParams = PhysicsParams{
+	Decay:           0.0014, // 0.9^62.5
+	MaxVelocity:     150,
+	RotDeadzone:     2,
+	CenteringForce:  0.0005,
+	MovementGain:    0.01,
+	PixelsPerSecond: 937.5, // 15 / 0.016
+	TickRate:        60,
+}
+
+// rejects parameters that would make the cursor run away or freeze
+func (p PhysicsParams) Validate() error {
+	for name, value := range map[string]float64{
+		"decay":           p.Decay,
+		"maxVelocity":     p.MaxVelocity,
+		"rotDeadzone":     p.RotDeadzone,
+		"centeringForce":  p.CenteringForce,
+		"movementGain":    p.MovementGain,
+		"pixelsPerSecond": p.PixelsPerSecond,
+	} {
+		if math.IsNaN(value) || math.IsInf(value, 0) || value < 0 {
+			return fmt.Errorf("physics %s must be a non-negative number, got %v", name, value)
+		}
+	}
+	if p.Decay >= 1 {
+		return fmt.Errorf("physics decay must be below 1 or the cursor never stops, got %v", p.Decay)
+	}
+	if p.Decay == 0 {
+		return fmt.Errorf("physics decay must be above 0 or the cursor never starts")
+	}
+	if p.MaxVelocity == 0 || p.PixelsPerSecond == 0 {
+		return fmt.Errorf("physics maxVelocity and pixelsPerSecond must be above 0")
+	}
+	if !slices.Contains(PhysicsTickRates, p.TickRate) {
+		return fmt.Errorf("physics tickRate must be one of %v, got %d", PhysicsTickRates, p.TickRate)
+	}
+	return nil
+}
+
+// the old physics loop ran a frame every 16ms and friction and outputScale were per frame
+const legacyPhysicsFrames = 62.5
+
+// reads physics saved before decay and pixelsPerSecond replaced friction and outputScale,
+// so an existing config.json keeps its tuning instead of falling back to the defaults
+func (p *PhysicsParams) UnmarshalJSON(data []byte) error {
+	type fields PhysicsParams
+	var raw struct {
+		fields
+		Decay           *float64 `json:"decay"`
+		PixelsPerSecond *float64 `json:"pixelsPerSecond"`
+		Friction        *float64 `json:"friction"`
+		OutputScale     *float64 `json:"outputScale"`
+	}
+	raw.fields = fields(*p)
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*p = PhysicsParams(raw.fields)
+
+	switch {
+	case raw.Decay != nil:
+		p.Decay = *raw.Decay
+	case raw.Friction != nil:
+		p.Decay = math.Pow(*raw.Friction, legacyPhysicsFrames)
+	}
+	switch {
+	case raw.PixelsPerSecond != nil:
+		p.PixelsPerSecond = *raw.PixelsPerSecond
+	case raw.OutputScale != nil:
+		p.PixelsPerSecond = *raw.OutputScale * legacyPhysicsFrames
+	}
+	return nil
+}
+
+// physics as a client sends it in config_update. a field left out is nil and keeps
+// the current value, so a client that only knows about some of the parameters (or
+// none of the tick rate) can still change the ones it sends, zero included
+type PhysicsUpdate struct {
+	Decay           *float64 `json:"decay"`
+	MaxVelocity     *float64 `json:"maxVelocity"`
+	RotDeadzone     *float64 `json:"rotDeadzone"`
+	CenteringForce  *float64 `json:"centeringForce"`
+	MovementGain    *float64 `json:"movementGain"`
+	PixelsPerSecond *float64 `json:"pixelsPerSecond"`
+	TickRate        *int     `json:"tickRate"`
+}
+
+// whether the update sets anything at all
+func (u PhysicsUpdate) Empty() bool {
+	return u == PhysicsUpdate{}
+}
+
+// p with the fields the update sets replaced
+func (u PhysicsUpdate) Apply(p PhysicsParams) PhysicsParams {
+	for _, field := range []struct {
+		dst *float64
+		src *float64
+	}{
+		{&p.Decay, u.Decay},
+		{&p.MaxVelocity, u.MaxVelocity},
+		{&p.RotDeadzone, u.RotDeadzone},
+		{&p.CenteringForce, u.CenteringForce},
+		{&p.MovementGain, u.MovementGain},
+		{&p.PixelsPerSecond, u.PixelsPerSecond},
+	} {
+		if field.src != nil {
+			*field.dst = *field.src
+		}
+	}
+	if u.TickRate != nil {
+		p.TickRate = *u.TickRate
+	}
+	return p
+}
+
+// swaps the physics parameters, takes effect on the next frame
+func (c *PacketController) SetPhysics(params PhysicsParams) error {
+	if err := params.Validate(); err != nil {
+		return err
+	}
+	c.physicsMu.Lock()
+	defer c.physicsMu.Unlock()
+	c.physics = params
+	return nil
+}
+
+// time between physics frames
+func (p PhysicsParams) tickInterval() time.Duration {
+	return time.Second / time.Duration(p.TickRate)
+}
+
+func (c *PacketController) Physics() PhysicsParams {
+	c.physicsMu.RLock()
+	defer c.physicsMu.RUnlock()
+	return c.physics
+}
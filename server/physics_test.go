@@ -0,0 +1,189 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPhysicsParamsFromOldConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want PhysicsParams
+	}{
+		{
+			"old keys",
+			`{"friction": 0.9, "maxVelocity": 150, "rotDeadzone": 2, "centeringForce": 0.0005, "movementGain": 0.01, "outputScale": 15}`,
+			PhysicsParams{Decay: math.Pow(0.9, 62.5), MaxVelocity: 150, RotDeadzone: 2, CenteringForce: 0.0005, MovementGain: 0.01, PixelsPerSecond: 937.5, TickRate: 60},
+		},
+		{
+			"new keys win over old ones",
+			`{"friction": 0.5, "decay": 0.01, "outputScale": 1, "pixelsPerSecond": 500}`,
+			PhysicsParams{Decay: 0.01, MaxVelocity: 150, RotDeadzone: 2, CenteringForce: 0.0005, MovementGain: 0.01, PixelsPerSecond: 500, TickRate: 60},
+		},
+		{
+			"missing keys keep what was there",
+			`{"tickRate": 120}`,
+			PhysicsParams{Decay: 0.0014, MaxVelocity: 150, RotDeadzone: 2, CenteringForce: 0.0005, MovementGain: 0.01, PixelsPerSecond: 937.5, TickRate: 120},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// config.json is decoded over the defaults
+			got := DefaultPhysicsParams
+			if err := json.Unmarshal([]byte(tt.json), &got); err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(got.Decay-tt.want.Decay) > 1e-12 {
+				t.Fatalf("decay %v, want %v", got.Decay, tt.want.Decay)
+			}
+			got.Decay = tt.want.Decay
+			if got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+			if err := got.Validate(); err != nil {
+				t.Fatalf("loaded params do not validate: %v", err)
+			}
+		})
+	}
+}
+
+func TestPhysicsUpdate(t *testing.T) {
+	with := func(change func(p *PhysicsParams)) PhysicsParams {
+		p := DefaultPhysicsParams
+		change(&p)
+		return p
+	}
+
+	tests := []struct {
+		name  string
+		json  string
+		empty bool
+		want  PhysicsParams
+	}{
+		{"missing keeps everything", `{}`, true, DefaultPhysicsParams},
+		{"null keeps everything", `{"decay": null}`, true, DefaultPhysicsParams},
+		{"single field without tick rate", `{"maxVelocity": 80}`, false, with(func(p *PhysicsParams) { p.MaxVelocity = 80 })},
+		{"tick rate only", `{"tickRate": 240}`, false, with(func(p *PhysicsParams) { p.TickRate = 240 })},
+		{"centering force turned off", `{"centeringForce": 0}`, false, with(func(p *PhysicsParams) { p.CenteringForce = 0 })},
+		{"zero deadzone and gain", `{"rotDeadzone": 0, "movementGain": 0}`, false, with(func(p *PhysicsParams) {
+			p.RotDeadzone = 0
+			p.MovementGain = 0
+		})},
+		{
+			"full update",
+			`{"decay": 0.5, "maxVelocity": 10, "rotDeadzone": 1, "centeringForce": 0.1, "movementGain": 0.2, "pixelsPerSecond": 100, "tickRate": 120}`,
+			false,
+			PhysicsParams{0.5, 10, 1, 0.1, 0.2, 100, 120},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var update PhysicsUpdate
+			if err := json.Unmarshal([]byte(tt.json), &update); err != nil {
+				t.Fatal(err)
+			}
+			if update.Empty() != tt.empty {
+				t.Fatalf("Empty() = %v, want %v", update.Empty(), tt.empty)
+			}
+			got := update.Apply(DefaultPhysicsParams)
+			if got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+			if err := got.Validate(); err != nil {
+				t.Fatalf("updated params do not validate: %v", err)
+			}
+		})
+	}
+}
+
+// pushes the cursor off at the same speed and lets the physics run for half a second
+// at the given tick rate, returning the distance moved and the velocity left over
+func coast(t *testing.T, tickRate int) (int, float64) {
+	c, mouse, _, clock := newTestController(t)
+	params := DefaultPhysicsParams
+	params.TickRate = tickRate
+	if err := c.SetPhysics(params); err != nil {
+		t.Fatal(err)
+	}
+	c.physicsMu.Lock()
+	c.velocityX = 50
+	c.physicsMu.Unlock()
+
+	frame := time.Second / time.Duration(tickRate)
+	for range tickRate / 2 {
+		clock.Advance(frame)
+		c.StepPhysics()
+	}
+
+	c.physicsMu.Lock()
+	defer c.physicsMu.Unlock()
+	x, _ := movedBy(mouse.Events())
+	return x, c.velocityX
+}
+
+func TestPhysicsSameAtEveryTickRate(t *testing.T) {
+	wantDistance, wantVelocity := coast(t, 60)
+	if wantDistance < 1000 {
+		t.Fatalf("moved only %dpx at 60hz", wantDistance)
+	}
+	for _, tickRate := range PhysicsTickRates {
+		t.Run(fmt.Sprintf("%dhz", tickRate), func(t *testing.T) {
+			distance, velocity := coast(t, tickRate)
+			if math.Abs(float64(distance-wantDistance)) > 1 {
+				t.Fatalf("moved %dpx, 60hz moved %dpx", distance, wantDistance)
+			}
+			if math.Abs(velocity-wantVelocity) > 1e-6 {
+				t.Fatalf("velocity %v after half a second, 60hz has %v", velocity, wantVelocity)
+			}
+		})
+	}
+}
+
+func TestPhysicsValidateTickRate(t *testing.T) {
+	for _, tickRate := range []int{0, -60, 30, 90, 144, 1000} {
+		params := DefaultPhysicsParams
+		params.TickRate = tickRate
+		if err := params.Validate(); err == nil {
+			t.Errorf("tick rate %d accepted", tickRate)
+		}
+	}
+	for _, tickRate := range PhysicsTickRates {
+		params := DefaultPhysicsParams
+		params.TickRate = tickRate
+		if err := params.Validate(); err != nil {
+			t.Errorf("tick rate %d rejected: %v", tickRate, err)
+		}
+	}
+}
+
+func TestPhysicsValidateDecay(t *testing.T) {
+	for _, decay := range []float64{0, 1, 1.5, -0.1, math.NaN(), math.Inf(1)} {
+		params := DefaultPhysicsParams
+		params.Decay = decay
+		if err := params.Validate(); err == nil {
+			t.Errorf("decay %v accepted", decay)
+		}
+	}
+
+	// the smallest decay still moves the cursor
+	c, mouse, _, clock := newTestController(t)
+	params := DefaultPhysicsParams
+	params.Decay = 1e-300
+	if err := c.SetPhysics(params); err != nil {
+		t.Fatal(err)
+	}
+	c.physicsMu.Lock()
+	c.velocityX = 50
+	c.physicsMu.Unlock()
+	clock.Advance(16 * time.Millisecond)
+	c.StepPhysics()
+	if x, _ := movedBy(mouse.Events()); x <= 0 {
+		t.Fatal("a tiny decay froze the cursor")
+	}
+}
@@ -0,0 +1,144 @@
+package server
+
+// per-session flood protection. every packet type falls in a class with its own token
+// bucket, so a client spamming mouse_move cannot also drown out its clicks, and a
+// broken build cannot hammer the mouse backend or rewrite the config file in a loop.
+// packets over budget are dropped and counted. releases get a budget of their own well
+// above the click one: dropping a release would leave a button or key held down on the
+// host, so a client pressing as fast as it is allowed always gets its releases through,
+// while one that only spams releases still cannot flood the backend.
+
+import (
+	"sync"
+	"time"
+)
+
+type PacketClass int
+
+const (
+	ClassMotion PacketClass = iota // mouse_move, scroll_move/end, device_motion, calibration
+	ClassClick                     // button and key presses, taps and clicks
+	ClassConfig                    // config_update
+	ClassOther
+	ClassRelease // button and key releases
+	packetClassCount
+)
+
+func (c PacketClass) String() string {
+	switch c {
+	case ClassMotion:
+		return "motion"
+	case ClassClick:
+		return "click"
+	case ClassConfig:
+		return "config"
+	case ClassRelease:
+		return "release"
+	default:
+		return "other"
+	}
+}
+
+// which budget a packet is charged against. the button packet carries its action
+// inside, which is why this takes the decoded packet and not just its type
+func ClassifyPacket(packet Packet) PacketClass {
+	switch packet.Type() {
+	case MouseMove, ScrollMove, ScrollEnd, DeviceMotion, Calibration:
+		return ClassMotion
+	case LeftClickUp, RightClickUp, ButtonUp, KeyUp:
+		return ClassRelease
+	case ButtonEvent:
+		if p, ok := packet.(*ButtonPacket); ok && p.Action == ActionUp {
+			return ClassRelease
+		}
+		return ClassClick
+	case LeftClickDown, RightClickDown, ButtonDown, KeyDown, KeyTap:
+		return ClassClick
+	case ConfigUpdate:
+		return ClassConfig
+	default:
+		return ClassOther
+	}
+}
+
+// refill rate per second and burst size for one class
+type BucketLimit struct {
+	Rate  float64
+	Burst float64
+}
+
+// motion allows for 120hz touch events plus 60hz gyro samples with room for jitter
+var DefaultPacketLimits = [packetClassCount]BucketLimit{
+	ClassMotion: {Rate: 300, Burst: 100},
+	ClassClick:  {Rate: 30, Burst: 10},
+	ClassConfig: {Rate: 2, Burst: 5},
+	ClassOther:  {Rate: 20, Burst: 20},
+	// releases keep up with a client retrying presses well past the click budget
+	ClassRelease: {Rate: 100, Burst: 50},
+}
+
+type tokenBucket struct {
+	limit  BucketLimit
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) allow(now time.Time) bool {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+		if b.tokens > b.limit.Burst {
+			b.tokens = b.limit.Burst
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+type PacketLimiter struct {
+	mu      sync.Mutex
+	buckets [packetClassCount]tokenBucket
+	dropped [packetClassCount]uint64
+}
+
+func NewPacketLimiter(limits [packetClassCount]BucketLimit) *PacketLimiter {
+	l := &PacketLimiter{}
+	for class, limit := range limits {
+		// start full so a fresh session is not throttled on its first burst
+		l.buckets[class] = tokenBucket{limit: limit, tokens: limit.Burst}
+	}
+	return l
+}
+
+// charges one packet against its class budget, false means drop it
+func (l *PacketLimiter) Allow(packet Packet, now time.Time) bool {
+	class := ClassifyPacket(packet)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.buckets[class].allow(now) {
+		return true
+	}
+	l.dropped[class]++
+	return false
+}
+
+// number of packets dropped so far in a class
+func (l *PacketLimiter) Dropped(class PacketClass) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.dropped[class]
+}
+
+// number of packets dropped so far across all classes
+func (l *PacketLimiter) TotalDropped() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var total uint64
+	for _, n := range l.dropped {
+		total += n
+	}
+	return total
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClassifyPacket(t *testing.T) {
+	tests := []struct {
+		packet Packet
+		want   PacketClass
+	}{
+		{&MouseMovePacket{}, ClassMotion},
+		{&DeviceMotionPacket{}, ClassMotion},
+		{&ScrollEndPacket{}, ClassMotion},
+		{&LeftClickDownPacket{}, ClassClick},
+		{&LeftClickUpPacket{}, ClassRelease},
+		{&RightClickUpPacket{}, ClassRelease},
+		{&ButtonDownPacket{Button: ButtonBack}, ClassClick},
+		{&ButtonUpPacket{Button: ButtonBack}, ClassRelease},
+		{&ButtonPacket{Button: ButtonLeft, Action: ActionDown}, ClassClick},
+		{&ButtonPacket{Button: ButtonLeft, Action: ActionClick}, ClassClick},
+		{&ButtonPacket{Button: ButtonLeft, Action: ActionUp}, ClassRelease},
+		{&KeyDownPacket{Key: "shift"}, ClassClick},
+		{&KeyTapPacket{Key: "a"}, ClassClick},
+		{&KeyUpPacket{Key: "shift"}, ClassRelease},
+		{&ConfigUpdatePacket{}, ClassConfig},
+		{&TextInputPacket{}, ClassOther},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.packet.Type()), func(t *testing.T) {
+			if got := ClassifyPacket(tt.packet); got != tt.want {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReleasesSurviveClickFlood(t *testing.T) {
+	tests := []struct {
+		name           string
+		press, release Packet
+	}{
+		{"keys", &KeyDownPacket{Key: "a"}, &KeyUpPacket{Key: "a"}},
+		{"legacy clicks", &LeftClickDownPacket{}, &LeftClickUpPacket{}},
+		{"button events", &ButtonPacket{Button: ButtonLeft, Action: ActionDown}, &ButtonPacket{Button: ButtonLeft, Action: ActionUp}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := NewPacketLimiter(DefaultPacketLimits)
+			now := time.Unix(0, 0)
+			pressed, released := 0, 0
+			// 50 presses and releases a second, well over the click budget
+			for range 200 {
+				if limiter.Allow(tt.press, now) {
+					pressed++
+				}
+				if limiter.Allow(tt.release, now) {
+					released++
+				}
+				now = now.Add(20 * time.Millisecond)
+			}
+			if released != 200 {
+				t.Fatalf("%d of 200 releases dropped", 200-released)
+			}
+			if pressed == 200 || limiter.Dropped(ClassClick) == 0 {
+				t.Fatal("presses were not limited")
+			}
+			if limiter.Dropped(ClassRelease) != 0 {
+				t.Fatalf("counted %d dropped releases", limiter.Dropped(ClassRelease))
+			}
+		})
+	}
+}
+
+func TestReleaseOnlyFloodIsLimited(t *testing.T) {
+	for _, release := range []Packet{
+		&KeyUpPacket{Key: "a"},
+		&ButtonUpPacket{Button: ButtonLeft},
+		&ButtonPacket{Button: ButtonLeft, Action: ActionUp},
+	} {
+		t.Run(string(release.Type()), func(t *testing.T) {
+			limiter := NewPacketLimiter(DefaultPacketLimits)
+			now := time.Unix(0, 0)
+			allowed := 0
+			// a second of releases at 1000 a second with nothing pressed
+			for range 1000 {
+				if limiter.Allow(release, now) {
+					allowed++
+				}
+				now = now.Add(time.Millisecond)
+			}
+			limit := DefaultPacketLimits[ClassRelease]
+			if max := int(limit.Burst + limit.Rate); allowed > max {
+				t.Fatalf("%d releases let through, want at most %d", allowed, max)
+			}
+			if limiter.Dropped(ClassRelease) == 0 {
+				t.Fatal("no releases dropped")
+			}
+			// the flood does not eat into the other budgets
+			if !limiter.Allow(&KeyDownPacket{Key: "a"}, now) {
+				t.Fatal("press dropped after a release flood")
+			}
+		})
+	}
+}
+
+func TestMotionBudget(t *testing.T) {
+	limiter := NewPacketLimiter(DefaultPacketLimits)
+	now := time.Unix(0, 0)
+	allowed := 0
+	for range 1000 {
+		if limiter.Allow(&MouseMovePacket{}, now) {
+			allowed++
+		}
+	}
+	if want := int(DefaultPacketLimits[ClassMotion].Burst); allowed != want {
+		t.Fatalf("burst let %d through, want %d", allowed, want)
+	}
+
+	// a second later the bucket has refilled up to its burst again
+	if !limiter.Allow(&MouseMovePacket{}, now.Add(time.Second)) {
+		t.Fatal("bucket did not refill")
+	}
+	if limiter.TotalDropped() != 1000-uint64(allowed) {
+		t.Fatalf("dropped %d, want %d", limiter.TotalDropped(), 1000-allowed)
+	}
+}
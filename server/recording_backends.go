@@ -0,0 +1,147 @@
+package server
+
+// backends that do nothing but write down what they were asked to do, so the
+// controller can be exercised without /dev/uinput or a display server
+
+import (
+	"fmt"
+	"sync"
+)
+
+// one call made on a recording backend
+type InputEvent struct {
+	Action string // move, press, release, click, scroll, center, key_down, key_up, key_tap, type
+	X, Y   int32  // deltas for move and scroll
+	Button Button
+	Key    string
+	Keys   []string // modifiers for key_tap
+	Text   string
+}
+
+func (e InputEvent) String() string {
+	switch e.Action {
+	case "move", "scroll":
+		return fmt.Sprintf("%s(%d, %d)", e.Action, e.X, e.Y)
+	case "press", "release", "click":
+		return fmt.Sprintf("%s(%s)", e.Action, e.Button)
+	case "key_down", "key_up":
+		return fmt.Sprintf("%s(%s)", e.Action, e.Key)
+	case "key_tap":
+		return fmt.Sprintf("%s(%s, %v)", e.Action, e.Key, e.Keys)
+	case "type":
+		return fmt.Sprintf("%s(%q)", e.Action, e.Text)
+	default:
+		return e.Action
+	}
+}
+
+type eventLog struct {
+	mu     sync.Mutex
+	events []InputEvent
+}
+
+func (l *eventLog) record(event InputEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+	return nil
+}
+
+// a copy of everything recorded so far
+func (l *eventLog) Events() []InputEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]InputEvent(nil), l.events...)
+}
+
+func (l *eventLog) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = nil
+}
+
+type RecordingMouse struct {
+	eventLog
+	X, Y int // position as far as the recorded moves go
+}
+
+func NewRecordingMouse() *RecordingMouse {
+	return &RecordingMouse{}
+}
+
+func (m *RecordingMouse) MoveRelative(dx, dy int32) error {
+	m.mu.Lock()
+	m.X += int(dx)
+	m.Y += int(dy)
+	m.mu.Unlock()
+	return m.record(InputEvent{Action: "move", X: dx, Y: dy})
+}
+
+func (m *RecordingMouse) MoveTo(x, y int) error {
+	m.mu.Lock()
+	dx, dy := int32(x-m.X), int32(y-m.Y)
+	m.X, m.Y = x, y
+	m.mu.Unlock()
+	return m.record(InputEvent{Action: "move", X: dx, Y: dy})
+}
+
+func (m *RecordingMouse) Click(button Button) error {
+	return m.record(InputEvent{Action: "click", Button: button})
+}
+
+func (m *RecordingMouse) Press(button Button) error {
+	return m.record(InputEvent{Action: "press", Button: button})
+}
+
+func (m *RecordingMouse) Release(button Button) error {
+	return m.record(InputEvent{Action: "release", Button: button})
+}
+
+func (m *RecordingMouse) GetPosition() (int, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.X, m.Y, nil
+}
+
+func (m *RecordingMouse) Scroll(deltaX, deltaY int32) error {
+	return m.record(InputEvent{Action: "scroll", X: deltaX, Y: deltaY})
+}
+
+func (m *RecordingMouse) CenterOnMainDisplay() error {
+	m.mu.Lock()
+	m.X, m.Y = 0, 0
+	m.mu.Unlock()
+	return m.record(InputEvent{Action: "center"})
+}
+
+func (m *RecordingMouse) Close() error {
+	return nil
+}
+
+type RecordingKeyboard struct {
+	eventLog
+}
+
+func NewRecordingKeyboard() *RecordingKeyboard {
+	return &RecordingKeyboard{}
+}
+
+func (k *RecordingKeyboard) KeyDown(key string) error {
+	return k.record(InputEvent{Action: "key_down", Key: key})
+}
+
+func (k *RecordingKeyboard) KeyUp(key string) error {
+	return k.record(InputEvent{Action: "key_up", Key: key})
+}
+
+func (k *RecordingKeyboard) KeyTap(key string, modifiers []string) error {
+	return k.record(InputEvent{Action: "key_tap", Key: key, Keys: modifiers})
+}
+
+func (k *RecordingKeyboard) TypeText(text string) error {
+	return k.record(InputEvent{Action: "type", Text: text})
+}
+
+func (k *RecordingKeyboard) Close() error {
+	return nil
+}
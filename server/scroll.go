@@ -0,0 +1,156 @@
+package server
+
+// scroll deltas arrive as floats in wheel notches. backends that can scroll in
+// fractions of a notch get 1/120ths, the rest get whole notches, and whatever did
+// not fit is carried over to the next packet so slow scrolls still add up. with
+// kinetic scrolling on, a scroll_end keeps the page moving at the speed the finger
+// left it, slowing down in the physics loop until it stops.
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// fractions of a notch per notch in hi-res wheel events, same as the kernel's
+const hiResScrollUnits = 120
+
+// share of the scroll speed left after one second of coasting
+const kineticScrollDecay = 0.04
+
+// momentum below this many notches per second is stopped
+const kineticScrollMinVelocity = 0.5
+
+// a scroll_end this long after the last scroll_move means the finger stopped before
+// lifting, so there is no momentum to carry
+const kineticScrollMaxIdle = 100 * time.Millisecond
+
+// shortest time a scroll speed is measured over, one 60hz touch frame. wi-fi hands
+// packets over in bunches a millisecond apart, which would otherwise read as a huge flick
+const kineticScrollMinGap = 16 * time.Millisecond
+
+// fastest momentum on either axis, in notches per second
+const kineticScrollMaxVelocity = 150.0
+
+type scrollState struct {
+	remainderX float64 // scroll not sent yet, in notches
+	remainderY float64
+	velocityX  float64 // smoothed scroll speed in notches per second
+	velocityY  float64
+	lastSample time.Time
+	kinetic    bool // momentum enabled at all
+	coasting   bool // momentum currently running
+}
+
+// turns kinetic scrolling on or off
+func (c *PacketController) SetKineticScroll(enabled bool) {
+	c.physicsMu.Lock()
+	defer c.physicsMu.Unlock()
+	c.scroll.kinetic = enabled
+	if !enabled {
+		c.scroll.coasting = false
+	}
+}
+
+// sends a scroll in notches, keeping the fraction that could not be sent
+// must be called with physicsMu held
+func (c *PacketController) scrollBy(deltaX, deltaY float64) error {
+	if !usableDelta(deltaX) || !usableDelta(deltaY) {
+		return fmt.Errorf("unusable scroll (%v, %v)", deltaX, deltaY)
+	}
+	// a change of direction starts over, leftovers from the other way would only eat into it
+	if deltaX*c.scroll.remainderX < 0 {
+		c.scroll.remainderX = 0
+	}
+	if deltaY*c.scroll.remainderY < 0 {
+		c.scroll.remainderY = 0
+	}
+	c.scroll.remainderX += deltaX
+	c.scroll.remainderY += deltaY
+
+	if c.mouse.SupportsHiResScroll() {
+		unitsX := wholePart(c.scroll.remainderX * hiResScrollUnits)
+		unitsY := wholePart(c.scroll.remainderY * hiResScrollUnits)
+		if unitsX == 0 && unitsY == 0 {
+			return nil
+		}
+		c.scroll.remainderX -= unitsX / hiResScrollUnits
+		c.scroll.remainderY -= unitsY / hiResScrollUnits
+		return c.mouse.ScrollHiRes(int32(unitsX), int32(unitsY))
+	}
+
+	notchesX := wholePart(c.scroll.remainderX)
+	notchesY := wholePart(c.scroll.remainderY)
+	if notchesX == 0 && notchesY == 0 {
+		return nil
+	}
+	c.scroll.remainderX -= notchesX
+	c.scroll.remainderY -= notchesY
+	return c.mouse.Scroll(int32(notchesX), int32(notchesY))
+}
+
+// handles a scroll from the client, tracking its speed for momentum
+func (c *PacketController) scrollSample(deltaX, deltaY float64, now time.Time) error {
+	// checked before the momentum picks it up, not only in scrollBy
+	if !usableDelta(deltaX) || !usableDelta(deltaY) {
+		return fmt.Errorf("unusable scroll (%v, %v)", deltaX, deltaY)
+	}
+
+	c.physicsMu.Lock()
+	defer c.physicsMu.Unlock()
+
+	// a finger back on the glass stops any momentum from the last flick
+	c.scroll.coasting = false
+
+	if !c.scroll.lastSample.IsZero() {
+		if dt := now.Sub(c.scroll.lastSample).Seconds(); dt >= 0 && dt < kineticScrollMaxIdle.Seconds() {
+			dt = math.Max(dt, kineticScrollMinGap.Seconds())
+			c.scroll.velocityX = clampScrollVelocity(0.5*c.scroll.velocityX + 0.5*deltaX/dt)
+			c.scroll.velocityY = clampScrollVelocity(0.5*c.scroll.velocityY + 0.5*deltaY/dt)
+		} else {
+			c.scroll.velocityX, c.scroll.velocityY = 0, 0
+		}
+	}
+	c.scroll.lastSample = now
+
+	return c.scrollBy(deltaX, deltaY)
+}
+
+func clampScrollVelocity(v float64) float64 {
+	return math.Max(-kineticScrollMaxVelocity, math.Min(kineticScrollMaxVelocity, v))
+}
+
+// the finger lifted, start coasting if kinetic scrolling is on and it was still moving
+func (c *PacketController) scrollEnd(now time.Time) {
+	c.physicsMu.Lock()
+	defer c.physicsMu.Unlock()
+
+	recent := now.Sub(c.scroll.lastSample) < kineticScrollMaxIdle
+	c.scroll.lastSample = time.Time{}
+	if !c.scroll.kinetic || !recent {
+		c.scroll.velocityX, c.scroll.velocityY = 0, 0
+		return
+	}
+	c.scroll.coasting = true
+	c.logIfEnabled("Kinetic scroll started at (%.1f, %.1f) notches/s", c.scroll.velocityX, c.scroll.velocityY)
+}
+
+// one physics frame of momentum, must be called with physicsMu held
+func (c *PacketController) updateKineticScroll(dt float64) {
+	if !c.scroll.coasting {
+		return
+	}
+
+	decay := math.Pow(kineticScrollDecay, dt)
+	c.scroll.velocityX *= decay
+	c.scroll.velocityY *= decay
+	if math.Hypot(c.scroll.velocityX, c.scroll.velocityY) < kineticScrollMinVelocity {
+		c.scroll.velocityX, c.scroll.velocityY = 0, 0
+		c.scroll.coasting = false
+		return
+	}
+
+	if err := c.scrollBy(c.scroll.velocityX*dt, c.scroll.velocityY*dt); err != nil {
+		c.logIfEnabled("Kinetic scroll error: %v", err)
+	}
+}
@@ -0,0 +1,173 @@
+package server
+
+// every authenticated websocket gets a session in the registry. all sessions share
+// the one PacketController, so the registry decides which of them is allowed to
+// drive it at any moment according to the arbitration policy:
+//
+//	exclusive   - the first session to send input keeps control until it disconnects
+//	last-active - whoever sent input most recently takes over, once the current
+//	              holder has been idle for handoffDelay (stops two people fighting)
+//	shared      - everyone drives the mouse at the same time, like before
+//
+// button and key releases are not arbitrated, a session that loses control while
+// holding a button still gets to let go of it.
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type ArbitrationPolicy string
+
+const (
+	ArbitrationExclusive  ArbitrationPolicy = "exclusive"
+	ArbitrationLastActive ArbitrationPolicy = "last-active"
+	ArbitrationShared     ArbitrationPolicy = "shared"
+)
+
+// how long the holder has to be idle before last-active lets someone else take over
+const handoffDelay = 500 * time.Millisecond
+
+// parses a policy name from the config or command line, empty means the default
+func ParseArbitrationPolicy(name string) (ArbitrationPolicy, error) {
+	switch policy := ArbitrationPolicy(name); policy {
+	case "":
+		return ArbitrationLastActive, nil
+	case ArbitrationExclusive, ArbitrationLastActive, ArbitrationShared:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown arbitration policy %q (want exclusive, last-active or shared)", name)
+	}
+}
+
+// one connected, authenticated client
+type Session struct {
+	ID           string
+	DeviceName   string
+	RemoteAddr   string
+	ConnectedAt  time.Time
+	LastActivity time.Time
+	Dropped      uint64 // packets thrown away by the rate limiter
+}
+
+type SessionRegistry struct {
+	mu       sync.Mutex
+	policy   ArbitrationPolicy
+	sessions map[string]*Session
+	holderID string // session currently in control, empty when nobody is
+	nextID   int
+}
+
+func NewSessionRegistry(policy ArbitrationPolicy) *SessionRegistry {
+	return &SessionRegistry{
+		policy:   policy,
+		sessions: make(map[string]*Session),
+	}
+}
+
+func (r *SessionRegistry) Policy() ArbitrationPolicy {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.policy
+}
+
+// registers a newly authenticated client and returns a copy of its session
+func (r *SessionRegistry) Add(deviceName, remoteAddr string, now time.Time) Session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	session := &Session{
+		ID:           strconv.Itoa(r.nextID),
+		DeviceName:   deviceName,
+		RemoteAddr:   remoteAddr,
+		ConnectedAt:  now,
+		LastActivity: now,
+	}
+	if session.DeviceName == "" {
+		session.DeviceName = remoteAddr
+	}
+	r.sessions[session.ID] = session
+	return *session
+}
+
+// drops a session, releasing control if it held it
+func (r *SessionRegistry) Remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.sessions, id)
+	if r.holderID == id {
+		r.holderID = ""
+	}
+}
+
+// records input from a session and reports whether it may drive the controller
+func (r *SessionRegistry) Acquire(id string, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session, exists := r.sessions[id]
+	if !exists {
+		return false
+	}
+	session.LastActivity = now
+
+	switch r.policy {
+	case ArbitrationShared:
+		r.holderID = id
+		return true
+	case ArbitrationExclusive:
+		if r.holderID == "" {
+			r.holderID = id
+		}
+		return r.holderID == id
+	default: // last-active
+		holder, exists := r.sessions[r.holderID]
+		if !exists || holder.ID == id || now.Sub(holder.LastActivity) >= handoffDelay {
+			r.holderID = id
+			return true
+		}
+		return false
+	}
+}
+
+// updates the dropped packet count shown for a session
+func (r *SessionRegistry) SetDropped(id string, dropped uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if session, exists := r.sessions[id]; exists {
+		session.Dropped = dropped
+	}
+}
+
+// id of the session currently in control, empty when nobody has sent input yet
+func (r *SessionRegistry) Holder() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.holderID
+}
+
+func (r *SessionRegistry) Count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.sessions)
+}
+
+// snapshot of all sessions, oldest first
+func (r *SessionRegistry) List() []Session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sessions := make([]Session, 0, len(r.sessions))
+	for _, session := range r.sessions {
+		sessions = append(sessions, *session)
+	}
+	slices.SortFunc(sessions, func(a, b Session) int {
+		return a.ConnectedAt.Compare(b.ConnectedAt)
+	})
+	return sessions
+}
@@ -0,0 +1,143 @@
+package server
+
+// the trust store remembers phones that paired with the QR code so they can reconnect
+// after a server restart without scanning again. a paired phone gets a long-lived random
+// device token, only the sha-256 of that token is written to disk, so a leaked devices
+// file cannot be replayed. revoking a device just deletes its entry.
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+type PairedDevice struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	TokenHash string    `json:"tokenHash"`
+	PairedAt  time.Time `json:"pairedAt"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+type TrustStore struct {
+	mu      sync.Mutex
+	path    string
+	devices []PairedDevice
+}
+
+// loads the trust store from path, a missing file is just an empty store
+func LoadTrustStore(path string) (*TrustStore, error) {
+	store := &TrustStore{path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trust store: %v", err)
+	}
+	if err := json.Unmarshal(data, &store.devices); err != nil {
+		return nil, fmt.Errorf("failed to parse trust store %s: %v", path, err)
+	}
+	return store, nil
+}
+
+// must be called with mu held
+func (s *TrustStore) save() error {
+	data, err := json.MarshalIndent(s.devices, "", "  ")
+	if err != nil {
+		return err
+	}
+	// tokens are hashed, but the list of paired devices is still nobody else's business
+	return os.WriteFile(s.path, data, 0600)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	bytes := make([]byte, n)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// remembers a newly paired device and returns the token to hand to it
+// the plain token is never stored, so this is the only chance to send it
+func (s *TrustStore) Pair(name string, now time.Time) (string, PairedDevice, error) {
+	token, err := randomHex(32)
+	if err != nil {
+		return "", PairedDevice{}, fmt.Errorf("failed to generate device token: %v", err)
+	}
+	id, err := randomHex(4)
+	if err != nil {
+		return "", PairedDevice{}, fmt.Errorf("failed to generate device id: %v", err)
+	}
+
+	device := PairedDevice{
+		ID:        id,
+		Name:      name,
+		TokenHash: hashToken(token),
+		PairedAt:  now,
+		LastSeen:  now,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.devices = append(s.devices, device)
+	if err := s.save(); err != nil {
+		s.devices = s.devices[:len(s.devices)-1]
+		return "", PairedDevice{}, fmt.Errorf("failed to save trust store: %v", err)
+	}
+	return token, device, nil
+}
+
+// checks a device token, bumping the device's last seen time when it matches
+func (s *TrustStore) Verify(token string, now time.Time) (PairedDevice, bool) {
+	if token == "" {
+		return PairedDevice{}, false
+	}
+	hash := hashToken(token)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.devices {
+		if s.devices[i].TokenHash == hash {
+			s.devices[i].LastSeen = now
+			// last seen is informational, failing to persist it should not lock the device out
+			_ = s.save()
+			return s.devices[i], true
+		}
+	}
+	return PairedDevice{}, false
+}
+
+// forgets a paired device so its token stops working
+func (s *TrustStore) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := slices.IndexFunc(s.devices, func(d PairedDevice) bool { return d.ID == id })
+	if index < 0 {
+		return fmt.Errorf("no paired device with id %s", id)
+	}
+	s.devices = slices.Delete(s.devices, index, index+1)
+	return s.save()
+}
+
+// snapshot of all paired devices, oldest pairing first
+func (s *TrustStore) List() []PairedDevice {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.devices)
+}
@@ -0,0 +1,200 @@
+//go:build linux
+
+package server
+
+// a bare uinput pointer device. the uinput library only exposes left, right and
+// middle on its mouse and whole wheel notches, so the wayland backend drives the
+// kernel interface itself to get the side buttons browsers use for back and forward
+// and high resolution scrolling.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ioctls and event codes from linux/uinput.h and linux/input-event-codes.h
+const (
+	uiDevCreate  = 0x5501
+	uiDevDestroy = 0x5502
+	uiSetEvBit   = 0x40045564
+	uiSetKeyBit  = 0x40045565
+	uiSetRelBit  = 0x40045566
+
+	evSyn     = 0x00
+	evKey     = 0x01
+	evRel     = 0x02
+	synReport = 0
+
+	relX           = 0x00
+	relY           = 0x01
+	relHWheel      = 0x06
+	relWheel       = 0x08
+	relWheelHiRes  = 0x0b
+	relHWheelHiRes = 0x0c
+
+	btnLeft   = 0x110
+	btnRight  = 0x111
+	btnMiddle = 0x112
+	btnSide   = 0x113 // back
+	btnExtra  = 0x114 // forward
+
+	busUSB = 0x03
+)
+
+type inputEvent struct {
+	Time  syscall.Timeval
+	Type  uint16
+	Code  uint16
+	Value int32
+}
+
+type uinputUserDev struct {
+	Name       [80]byte
+	Bustype    uint16
+	Vendor     uint16
+	Product    uint16
+	Version    uint16
+	EffectsMax uint32
+	Absmax     [64]int32
+	Absmin     [64]int32
+	Absfuzz    [64]int32
+	Absflat    [64]int32
+}
+
+type uinputPointer struct {
+	file *os.File
+
+	// hi-res scroll not yet worth a whole notch, for the legacy wheel events
+	wheelRemainderX int32
+	wheelRemainderY int32
+}
+
+func ioctl(file *os.File, request, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), request, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func newUinputPointer(path, name string) (*uinputPointer, error) {
+	file, err := os.OpenFile(path, syscall.O_WRONLY|syscall.O_NONBLOCK, 0660)
+	if err != nil {
+		return nil, err
+	}
+
+	setup := []struct{ request, arg uintptr }{
+		{uiSetEvBit, evKey},
+		{uiSetEvBit, evRel},
+	}
+	for _, button := range []uintptr{btnLeft, btnRight, btnMiddle, btnSide, btnExtra} {
+		setup = append(setup, struct{ request, arg uintptr }{uiSetKeyBit, button})
+	}
+	for _, axis := range []uintptr{relX, relY, relWheel, relHWheel, relWheelHiRes, relHWheelHiRes} {
+		setup = append(setup, struct{ request, arg uintptr }{uiSetRelBit, axis})
+	}
+	for _, s := range setup {
+		if err := ioctl(file, s.request, s.arg); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to configure uinput device: %v", err)
+		}
+	}
+
+	dev := uinputUserDev{Bustype: busUSB, Vendor: 0x4711, Product: 0x0817, Version: 1}
+	copy(dev.Name[:len(dev.Name)-1], name)
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, dev)
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write uinput device description: %v", err)
+	}
+	if err := ioctl(file, uiDevCreate, 0); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to create uinput device: %v", err)
+	}
+	// give udev and the compositor a moment to pick the device up before it is used
+	time.Sleep(200 * time.Millisecond)
+
+	return &uinputPointer{file: file}, nil
+}
+
+// writes the events followed by a sync report in a single write
+func (d *uinputPointer) emit(events ...inputEvent) error {
+	var buf bytes.Buffer
+	for _, event := range append(events, inputEvent{Type: evSyn, Code: synReport}) {
+		binary.Write(&buf, binary.LittleEndian, event)
+	}
+	_, err := d.file.Write(buf.Bytes())
+	return err
+}
+
+func (d *uinputPointer) Move(dx, dy int32) error {
+	var events []inputEvent
+	if dx != 0 {
+		events = append(events, inputEvent{Type: evRel, Code: relX, Value: dx})
+	}
+	if dy != 0 {
+		events = append(events, inputEvent{Type: evRel, Code: relY, Value: dy})
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	return d.emit(events...)
+}
+
+func (d *uinputPointer) Button(code uint16, pressed bool) error {
+	var value int32
+	if pressed {
+		value = 1
+	}
+	return d.emit(inputEvent{Type: evKey, Code: code, Value: value})
+}
+
+// deltas are in wheel notches, positive y scrolls up
+func (d *uinputPointer) Wheel(dx, dy int32) error {
+	return d.WheelHiRes(dx*hiResScrollUnits, dy*hiResScrollUnits)
+}
+
+// deltas are in 1/120ths of a notch. applications that understand hi-res events
+// scroll smoothly, everything else still gets a plain wheel event per whole notch
+func (d *uinputPointer) WheelHiRes(dx, dy int32) error {
+	var events []inputEvent
+	if dy != 0 {
+		events = append(events, inputEvent{Type: evRel, Code: relWheelHiRes, Value: dy})
+		if notches := d.wheelNotches(&d.wheelRemainderY, dy); notches != 0 {
+			events = append(events, inputEvent{Type: evRel, Code: relWheel, Value: notches})
+		}
+	}
+	if dx != 0 {
+		events = append(events, inputEvent{Type: evRel, Code: relHWheelHiRes, Value: dx})
+		if notches := d.wheelNotches(&d.wheelRemainderX, dx); notches != 0 {
+			events = append(events, inputEvent{Type: evRel, Code: relHWheel, Value: notches})
+		}
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	return d.emit(events...)
+}
+
+// adds delta to remainder and takes out the whole notches it now holds
+func (d *uinputPointer) wheelNotches(remainder *int32, delta int32) int32 {
+	if delta*(*remainder) < 0 {
+		*remainder = 0
+	}
+	*remainder += delta
+	notches := *remainder / hiResScrollUnits
+	*remainder -= notches * hiResScrollUnits
+	return notches
+}
+
+func (d *uinputPointer) Close() error {
+	if err := ioctl(d.file, uiDevDestroy, 0); err != nil {
+		d.file.Close()
+		return err
+	}
+	return d.file.Close()
+}
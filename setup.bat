@@ -27,7 +27,6 @@ cd quick-mouse || (
 :: ---------- Install Required Tools ----------
 call :install_package go GoLang.Go go
 call :install_package nodejs OpenJS.NodeJS node
-call :install_package openssl FireDaemon.OpenSSL openssl
 
 :: ---------- Go Backend Build ----------
 echo.
@@ -67,42 +66,6 @@ if errorlevel 1 (
 )
 cd ..
 
-:: ---------- Certificate Generation ----------
-echo.
-if not exist certs mkdir certs
-
-:: Check OpenSSL with full path fallback
-set "OPENSSL_CMD=openssl"
-where openssl >nul 2>nul
-if errorlevel 1 (
-    :: Try common FireDaemon install path
-    set "FD_PATH=%ProgramFiles%\FireDaemon OpenSSL"
-    if exist "!FD_PATH!" (
-        for /d %%d in ("!FD_PATH!\*") do (
-            if exist "%%d\bin\openssl.exe" (
-                set "OPENSSL_CMD=%%d\bin\openssl.exe"
-                set "PATH=!PATH!;%%d\bin"
-                goto :openssl_found
-            )
-        )
-    )
-    echo [ERROR] OpenSSL not found in PATH or default install location.
-    echo         Install via: winget install FireDaemon.OpenSSL
-    exit /b 1
-)
-
-:openssl_found
-echo Generating TLS certificate for localhost...
-"%OPENSSL_CMD%" req -x509 -newkey rsa:4096 ^
-    -keyout certs\localhost-key.pem ^
-    -out certs\localhost.pem ^
-    -days 365 -nodes ^
-    -subj "/CN=localhost"
-if errorlevel 1 (
-    echo [ERROR] Certificate generation failed.
-    exit /b 1
-)
-
 :: ---------- Success ----------
 echo.
 echo ========================================
@@ -111,8 +74,7 @@ echo ========================================
 echo.
 echo Executable: quick-mouse.exe
 echo Client:     client/dist/ (or client/build/)
-echo Certs:      certs\localhost.pem
-echo             certs\localhost-key.pem
+echo Certs:      generated in certs\ on first run
 echo.
 echo Run: quick-mouse.exe
 echo.
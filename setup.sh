@@ -67,7 +67,6 @@ echo "Detected package manager: $MANAGER"
 
 install_package go "$MANAGER"
 install_package node "$MANAGER"
-install_package openssl "$MANAGER"
 
 echo "Installing Go dependencies..."
 go mod tidy
@@ -81,13 +80,5 @@ npm install
 npm run build
 cd ..
 
-mkdir -p certs
-
-echo "Generating TLS certificate..."
-openssl req -x509 -newkey rsa:4096 \
-  -keyout certs/localhost-key.pem \
-  -out certs/localhost.pem \
-  -days 365 -nodes -subj "/CN=localhost"
-
 echo "Installation done."
 echo "Run with: ./quick-mouse"